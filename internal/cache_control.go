@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kong/go-pdk"
+)
+
+// cacheControl 은 Cache-Control 헤더의 디렉티브를 담는다.
+// 디렉티브 이름은 소문자로 정규화하며, 값이 없는 디렉티브는 빈 문자열로 저장한다.
+//
+// See https://www.rfc-editor.org/rfc/rfc9111#section-5.2
+type cacheControl map[string]string
+
+// NOTE Kong proxy-cache 의 parse_directive_header 를 옮겼다
+func parseCacheControl(header string) cacheControl {
+	cc := cacheControl{}
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if _, ok := cc[name]; ok {
+			// 중복된 디렉티브는 처음 값을 쓴다
+			continue
+		}
+		cc[name] = value
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds 는 delta-seconds 값을 갖는 디렉티브를 읽는다. 값이 없거나 숫자가 아니면 false 를 돌려준다.
+func (cc cacheControl) seconds(directive string) (int64, bool) {
+	v, ok := cc[directive]
+	if !ok || v == "" {
+		return 0, false
+	}
+
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return secs, true
+}
+
+// resourceTTL 은 응답이 fresh 한 기간(초)을 계산한다.
+// s-maxage, max-age, Expires 순으로 본다. 아무 것도 없으면 0 이다.
+func resourceTTL(cc cacheControl, expires string, now time.Time) int {
+	if secs, ok := cc.seconds("s-maxage"); ok {
+		return int(secs)
+	}
+	if secs, ok := cc.seconds("max-age"); ok {
+		return int(secs)
+	}
+
+	if expires == "" {
+		return 0
+	}
+	expiresAt, err := http.ParseTime(expires)
+	if err != nil {
+		return 0
+	}
+	ttl := int(expiresAt.Sub(now).Seconds())
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// acceptableToClient 는 요청의 Cache-Control 기준으로 클라이언트가 캐시된 값을 받아들이는지 판단한다.
+// max-age, max-stale, min-fresh 만 본다.
+func acceptableToClient(reqCC cacheControl, cacheValue *CacheValue, now int64) bool {
+	age := now - cacheValue.Timestamp
+
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+
+	if maxStale, ok := reqCC.seconds("max-stale"); ok && age-cacheValue.freshnessLifetime() > maxStale {
+		return false
+	}
+
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok && cacheValue.freshnessLifetime()-age < minFresh {
+		return false
+	}
+
+	return true
+}
+
+// requestCacheControl 은 cache_control 모드일 때만 요청의 Cache-Control 헤더를 읽는다.
+func (conf *Config) requestCacheControl(kong *pdk.PDK) cacheControl {
+	if !conf.CacheControl {
+		return cacheControl{}
+	}
+
+	v, err := kong.Request.GetHeader("Cache-Control")
+	if err != nil {
+		conf.logger.Debug().Err(err).Msg("Failed to get request header `Cache-Control`")
+		return cacheControl{}
+	}
+	return parseCacheControl(v)
+}
+
+// responseCacheControl 은 업스트림 응답의 Cache-Control 헤더를 읽는다.
+func (conf *Config) responseCacheControl(kong *pdk.PDK) cacheControl {
+	v, err := kong.Response.GetHeader("Cache-Control")
+	if err != nil {
+		conf.logger.Debug().Err(err).Msg("Failed to get response header `Cache-Control`")
+		return cacheControl{}
+	}
+	return parseCacheControl(v)
+}
+
+// responseTTL 은 cache_control 모드에서 응답 헤더로부터 TTL 을 구한다.
+func (conf *Config) responseTTL(kong *pdk.PDK, resCC cacheControl) int {
+	expires, err := kong.Response.GetHeader("Expires")
+	if err != nil {
+		conf.logger.Debug().Err(err).Msg("Failed to get response header `Expires`")
+	}
+	return resourceTTL(resCC, expires, time.Now())
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   cacheControl
+	}{
+		{
+			name:   "empty header",
+			header: "",
+			want:   cacheControl{},
+		},
+		{
+			name:   "directives with and without values",
+			header: "public, max-age=60, s-maxage=\"120\"",
+			want: cacheControl{
+				"public":   "",
+				"max-age":  "60",
+				"s-maxage": "120",
+			},
+		},
+		{
+			name:   "directive names are case insensitive",
+			header: "No-Store,  MAX-AGE=5",
+			want: cacheControl{
+				"no-store": "",
+				"max-age":  "5",
+			},
+		},
+		{
+			name:   "first duplicated directive wins",
+			header: "max-age=5, max-age=10",
+			want: cacheControl{
+				"max-age": "5",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseCacheControl(tt.header))
+		})
+	}
+}
+
+func TestCacheControl_seconds(t *testing.T) {
+	cc := parseCacheControl("max-age=60, max-stale, min-fresh=abc, s-maxage=-1")
+
+	secs, ok := cc.seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, int64(60), secs)
+
+	_, ok = cc.seconds("max-stale")
+	assert.False(t, ok)
+
+	_, ok = cc.seconds("min-fresh")
+	assert.False(t, ok)
+
+	_, ok = cc.seconds("s-maxage")
+	assert.False(t, ok)
+
+	_, ok = cc.seconds("no-cache")
+	assert.False(t, ok)
+}
+
+func TestResourceTTL(t *testing.T) {
+	now := time.Date(2025, 2, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		header  string
+		expires string
+		want    int
+	}{
+		{
+			name:   "s-maxage wins over max-age",
+			header: "max-age=10, s-maxage=20",
+			want:   20,
+		},
+		{
+			name:   "max-age",
+			header: "max-age=10",
+			want:   10,
+		},
+		{
+			name:    "max-age wins over expires",
+			header:  "max-age=10",
+			expires: now.Add(time.Hour).Format(http.TimeFormat),
+			want:    10,
+		},
+		{
+			name:    "expires",
+			expires: now.Add(time.Minute).Format(http.TimeFormat),
+			want:    60,
+		},
+		{
+			name:    "expires in the past",
+			expires: now.Add(-time.Minute).Format(http.TimeFormat),
+			want:    0,
+		},
+		{
+			name:    "invalid expires",
+			expires: "0",
+			want:    0,
+		},
+		{
+			name: "nothing",
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, resourceTTL(parseCacheControl(tt.header), tt.expires, now))
+		})
+	}
+}
+
+func TestAcceptableToClient(t *testing.T) {
+	now := time.Now().Unix()
+	// 30초 전에 저장되었고 60초 동안 fresh 한 값
+	cacheValue := &CacheValue{Timestamp: now - 30, TTL: 60}
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "no directives", header: "", want: true},
+		{name: "max-age satisfied", header: "max-age=30", want: true},
+		{name: "max-age exceeded", header: "max-age=10", want: false},
+		{name: "max-stale satisfied", header: "max-stale=0", want: true},
+		{name: "min-fresh satisfied", header: "min-fresh=30", want: true},
+		{name: "min-fresh not satisfied", header: "min-fresh=31", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, acceptableToClient(parseCacheControl(tt.header), cacheValue, now))
+		})
+	}
+
+	// TTL 이 지난 값은 max-stale 범위 안에서만 받아들인다
+	expired := &CacheValue{Timestamp: now - 90, TTL: 60}
+	assert.True(t, acceptableToClient(parseCacheControl("max-stale=30"), expired, now))
+	assert.False(t, acceptableToClient(parseCacheControl("max-stale=10"), expired, now))
+}
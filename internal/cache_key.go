@@ -3,7 +3,6 @@ package internal
 import (
 	"fmt"
 	"github.com/Kong/go-pdk"
-	"github.com/go-playground/validator/v10"
 	"github.com/mitchellh/hashstructure/v2"
 	"strconv"
 )
@@ -52,7 +51,22 @@ func routeID(kong *pdk.PDK) (string, error) {
 }
 
 func NewCacheKey(kong *pdk.PDK, conf *Config, body []byte, cacheTTL int) (string, error) {
+	filter := conf.defaultFilter()
+	filter.CacheTTL = cacheTTL
+
+	cacheKey, err := newCacheKey(kong, conf, filter, body)
+	if err != nil {
+		return "", err
+	}
+	return generateCacheKeyID(conf.logger, cacheKey)
+}
+
+// newCacheKey 는 요청으로 CacheKey 를 만든다. Vary 헤더를 더하려면 해시하기 전에 Headers 를 고친다.
+// 쿼리 인자는 filter 의 설정대로 고른다. 본문은 filter 의 json_paths 로 뽑은 값을 쓰거나,
+// canonical_json_body 이면 정규화한다.
+func newCacheKey(kong *pdk.PDK, conf *Config, filter Filter, body []byte) (*CacheKey, error) {
 	logger := conf.logger
+	cacheTTL := filter.CacheTTL
 
 	consumerID, err := consumerID(kong)
 	if err != nil {
@@ -93,6 +107,7 @@ func NewCacheKey(kong *pdk.PDK, conf *Config, body []byte, cacheTTL int) (string
 	if path == "" {
 		logger.Debug().Msg("path is empty")
 	}
+	path = conf.cacheKeyPath(path)
 
 	//pathWithQuery, err := kong.Request.GetPathWithQuery()
 	//if err != nil {
@@ -116,6 +131,11 @@ func NewCacheKey(kong *pdk.PDK, conf *Config, body []byte, cacheTTL int) (string
 		logger.Error().Err(err).Msg("Getting queryArgs has failed")
 		//return
 	}
+	queryArgs = filter.cacheKeyQuery(queryArgs)
+	if conf.GraphQL && method == "GET" {
+		// GET 요청의 GraphQL 인자는 해석한 연산으로 본문 자리에 넣는다
+		queryArgs = withoutGraphQLQueryArgs(queryArgs)
+	}
 	if conf.isDebug() {
 		if queryArgs == nil {
 			logger.Debug().Msg("queryArgs is empty")
@@ -126,6 +146,15 @@ func NewCacheKey(kong *pdk.PDK, conf *Config, body []byte, cacheTTL int) (string
 		}
 	}
 
+	switch {
+	case conf.GraphQL:
+		// graphql 모드의 본문은 이미 GraphQL 연산으로 바꿨다
+	case len(filter.JSONPaths) > 0:
+		body = conf.jsonPathBody(kong, filter.JSONPaths, body)
+	case conf.CanonicalJSONBody:
+		body = conf.canonicalJSONBody(kong, body)
+	}
+
 	cacheKey := &CacheKey{
 		Consumer:  consumerID,
 		Service:   serviceID,
@@ -138,13 +167,13 @@ func NewCacheKey(kong *pdk.PDK, conf *Config, body []byte, cacheTTL int) (string
 		CacheTTL:  cacheTTL,
 	}
 
-	validate := validator.New()
+	validate := conf.validator()
 	if errs := validate.Struct(cacheKey); errs != nil {
 		logger.Error().Err(errs).Msg("validation error")
-		return "", errs
+		return nil, errs
 	}
 
-	return generateCacheKeyID(logger, cacheKey)
+	return cacheKey, nil
 }
 
 func generateCacheKeyID(logger *Logger, cacheKey *CacheKey) (string, error) {
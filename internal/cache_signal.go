@@ -4,6 +4,29 @@ package internal
 type CacheSignal struct {
 	CacheKeyID string `json:"cache_key_id" validate:"required"`
 	CacheTTL   int    `json:"cache_ttl" validate:"gte=0" default:"0"`
+	// 스토어에 보관할 기간(초). 0 이면 CacheTTL 과 stale 기간으로 정한다
+	StorageTTL int `json:"storage_ttl,omitempty" validate:"gte=0"`
+	// Vary 를 반영하기 전의 캐시 키. 응답의 Vary 헤더 이름을 이 키로 기록한다
+	BaseKeyID string `json:"base_key_id,omitempty"`
+	// 캐시 키에 반영한 Vary 헤더 이름
+	VaryHeaders []string `json:"vary_headers,omitempty"`
+	// 백그라운드 갱신 요청이면 true
+	Revalidate bool `json:"revalidate,omitempty"`
+	// stale 한 값의 검증자로 업스트림 요청에 조건부 헤더를 붙였다면 true
+	Conditional bool `json:"conditional,omitempty"`
+	// 캐시 미스를 모으는 잠금을 얻었다면 그 토큰. Response 에서 잠금을 푼다
+	LockToken string `json:"lock_token,omitempty"`
+	// 캐시 키에 넣은 경로. 저장할 때 경로별 인덱스에 기록한다
+	Path string `json:"path,omitempty"`
+	// 요청의 Kong 서비스, 라우트 ID. purge API 가 열려 있으면 저장할 때 인덱스에 기록한다
+	Service string `json:"service,omitempty"`
+	Route   string `json:"route,omitempty"`
+	// 안전하지 않은 메소드의 요청이면 true. 2xx 로 응답하면 Path 의 캐시를 지운다
+	Invalidate bool `json:"invalidate,omitempty"`
+	// Idempotency-Key 로 처음 처리하는 요청이면 true. CacheKeyID 에 응답을 저장한다
+	Idempotency bool `json:"idempotency,omitempty"`
+	// Idempotency-Key 요청의 메소드, 경로, 본문 해시
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 
 // NewCacheSignal creates a new CacheSignal instance
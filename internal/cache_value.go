@@ -1,6 +1,9 @@
 package internal
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+)
 
 // NOTE https://github.com/Kong/kong/blob/df14db5bf938e7d6cd9c0150336fe70fb96891c6/kong/plugins/proxy-cache/handler.lua#L414
 // 에서는 req_body까지 캐시에 저장하지만 굳이 그럴 필요가 있나 싶다.
@@ -14,8 +17,102 @@ type CacheValue struct {
 	TTL       int64  `validate:"required,gte=0"`
 	Version   string `validate:"required"`
 	ReqBody   []byte `validate:"required"`
+	// TTL 이 지난 뒤에도 백그라운드로 갱신하는 동안 응답할 수 있는 기간(초)
+	StaleWhileRevalidate int64 `validate:"gte=0"`
+	// TTL 이 지난 뒤에도 업스트림이 실패하면 대신 응답할 수 있는 기간(초)
+	StaleIfError int64 `validate:"gte=0"`
+	// 스토어에 보관할 기간(초). 0 이면 TTL 과 stale 기간으로 정한다
+	StorageTTL int64 `validate:"gte=0"`
+	// soft purge 한 시각(unix). 0 이 아니면 TTL 과 관계없이 stale 하다
+	Purged int64 `validate:"gte=0"`
+}
+
+// stale 은 TTL 이 지났거나 soft purge 되었는지 알려준다.
+// TTL 이 0 이면 스토어에서는 만료되지 않지만, 저장한 그 초가 지나면 stale 해서 다음 요청은 갱신한다.
+func (v *CacheValue) stale(now int64) bool {
+	return v.Purged > 0 || now-v.Timestamp > v.TTL
+}
+
+// replaces 는 v 가 old 를 갱신해 저장한 값인지 알려준다. old 가 nil 이면 언제나 true 다.
+// 갱신한 값은 soft purge 표시가 지워지고 Timestamp 가 갱신한 시각이므로, stale 한 old 와 둘 다 같을 수 없다.
+func (v *CacheValue) replaces(old *CacheValue) bool {
+	return old == nil || v.Timestamp != old.Timestamp || v.Purged != old.Purged
+}
+
+// freshnessLifetime 은 저장한 뒤 stale 해지기까지의 기간(초)이다. TTL 이 지나기 전에 soft purge 되었으면 그때까지다.
+func (v *CacheValue) freshnessLifetime() int64 {
+	if v.Purged > 0 && v.Purged-v.Timestamp < v.TTL {
+		return max(v.Purged-v.Timestamp, 0)
+	}
+	return v.TTL
+}
+
+// storageTTL 은 스토어에 보관할 기간(초)이다. storage_ttl 이 없으면 stale 한 값도 응답할 수 있도록 TTL 보다 길게 보관한다.
+// TTL 이 0 이면 만료되지 않는다.
+func (v *CacheValue) storageTTL() int {
+	if v.StorageTTL > 0 {
+		return int(v.StorageTTL)
+	}
+	if v.TTL <= 0 {
+		return 0
+	}
+	return int(v.TTL + max(v.StaleWhileRevalidate, v.StaleIfError))
+}
+
+// staleWhileRevalidate 는 TTL 이 지났지만 stale-while-revalidate 기간 안에 있는지 알려준다.
+func (v *CacheValue) staleWhileRevalidate(now int64) bool {
+	return v.stale(now) && v.StaleWhileRevalidate > 0 && now-v.Timestamp <= v.freshnessLifetime()+v.StaleWhileRevalidate
+}
+
+// clone 은 Headers 를 복사한 CacheValue 를 돌려준다. Body 는 고치지 않으므로 공유한다.
+func (v *CacheValue) clone() *CacheValue {
+	c := *v
+	if v.Headers != nil {
+		c.Headers = make(map[string][]string, len(v.Headers))
+		for k, vv := range v.Headers {
+			c.Headers[k] = append([]string(nil), vv...)
+		}
+	}
+	return &c
 }
 
 func (v *CacheValue) String() string {
 	return fmt.Sprintf("CacheValue{Status: %d, Headers: %v, BodyLen: %d, Timestamp: %d, TTL: %d, Version: %s}", v.Status, v.Headers, v.BodyLen, v.Timestamp, v.TTL, v.Version)
 }
+
+// usableOnError 는 업스트림이 실패했을 때 대신 응답할 수 있는지 알려준다.
+func (v *CacheValue) usableOnError(now int64) bool {
+	if v.Purged > 0 {
+		return v.StaleIfError > 0 && now-v.Timestamp <= v.freshnessLifetime()+v.StaleIfError
+	}
+	return now-v.Timestamp <= v.TTL+v.StaleIfError
+}
+
+// staleStatus 는 stale 한 값을 응답할 때의 X-Cache-Status 다. soft purge 된 값은 Purged 로 구분한다.
+func (v *CacheValue) staleStatus(status string) string {
+	if v.Purged > 0 {
+		return "Purged"
+	}
+	return status
+}
+
+// responseHeaders 는 캐시된 값을 응답할 때 쓸 헤더를 만든다.
+func (v *CacheValue) responseHeaders(cacheStatus string, now int64) map[string][]string {
+	for key := range v.Headers { //nolint:gosimple,gofmt
+		// NOTE: https://github.dev/Kong/kong/blob/master/kong/plugins/proxy-cache/handler.lua 를 베꼈는데 의미를 잘 모르겠다.
+		if !overwritableHeader(key) {
+			delete(v.Headers, key)
+		}
+
+		if headerToDelete(key) {
+			delete(v.Headers, key)
+		}
+	}
+	if v.Headers == nil {
+		v.Headers = map[string][]string{}
+	}
+
+	v.Headers["Age"] = []string{strconv.FormatInt(now-v.Timestamp, 10)}
+	v.Headers["X-Cache-Status"] = []string{cacheStatus}
+	return v.Headers
+}
@@ -332,3 +332,146 @@ func TestCacheValue_BodyLenConsistency(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheValue_stale(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name                     string
+		value                    CacheValue
+		wantStale                bool
+		wantStaleWhileRevalidate bool
+		wantStorageTTL           int
+	}{
+		{
+			name:           "fresh",
+			value:          CacheValue{Timestamp: now - 10, TTL: 60},
+			wantStorageTTL: 60,
+		},
+		{
+			// cache_ttl 이 0 이면 스토어에는 남지만 저장한 그 초가 지나면 갱신한다
+			name:           "zero ttl is fresh within the stored second",
+			value:          CacheValue{Timestamp: now, TTL: 0},
+			wantStorageTTL: 0,
+		},
+		{
+			name:           "zero ttl is stale after the stored second",
+			value:          CacheValue{Timestamp: now - 1, TTL: 0},
+			wantStale:      true,
+			wantStorageTTL: 0,
+		},
+		{
+			name:                     "zero ttl within stale-while-revalidate",
+			value:                    CacheValue{Timestamp: now - 20, TTL: 0, StaleWhileRevalidate: 30},
+			wantStale:                true,
+			wantStaleWhileRevalidate: true,
+			wantStorageTTL:           0,
+		},
+		{
+			name:           "stale without stale-while-revalidate",
+			value:          CacheValue{Timestamp: now - 61, TTL: 60},
+			wantStale:      true,
+			wantStorageTTL: 60,
+		},
+		{
+			name:                     "stale within stale-while-revalidate",
+			value:                    CacheValue{Timestamp: now - 80, TTL: 60, StaleWhileRevalidate: 30},
+			wantStale:                true,
+			wantStaleWhileRevalidate: true,
+			wantStorageTTL:           90,
+		},
+		{
+			name:           "stale beyond stale-while-revalidate",
+			value:          CacheValue{Timestamp: now - 91, TTL: 60, StaleWhileRevalidate: 30},
+			wantStale:      true,
+			wantStorageTTL: 90,
+		},
+		{
+			// stale-while-revalidate 기간은 soft purge 한 시각부터 센다
+			name:                     "soft purged within stale-while-revalidate",
+			value:                    CacheValue{Timestamp: now - 10, TTL: 60, StaleWhileRevalidate: 30, Purged: now - 5},
+			wantStale:                true,
+			wantStaleWhileRevalidate: true,
+			wantStorageTTL:           90,
+		},
+		{
+			name:           "soft purged beyond stale-while-revalidate",
+			value:          CacheValue{Timestamp: now - 50, TTL: 60, StaleWhileRevalidate: 30, Purged: now - 40},
+			wantStale:      true,
+			wantStorageTTL: 90,
+		},
+		{
+			name:           "soft purged zero ttl",
+			value:          CacheValue{Timestamp: now - 1000, TTL: 0, Purged: now},
+			wantStale:      true,
+			wantStorageTTL: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantStale, tt.value.stale(now))
+			assert.Equal(t, tt.wantStaleWhileRevalidate, tt.value.staleWhileRevalidate(now))
+			assert.Equal(t, tt.wantStorageTTL, tt.value.storageTTL())
+		})
+	}
+}
+
+func TestCacheValue_usableOnError(t *testing.T) {
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name  string
+		value CacheValue
+		want  bool
+	}{
+		{name: "fresh", value: CacheValue{Timestamp: now - 10, TTL: 60}, want: true},
+		{name: "stale without stale-if-error", value: CacheValue{Timestamp: now - 61, TTL: 60}, want: false},
+		{name: "stale within stale-if-error", value: CacheValue{Timestamp: now - 100, TTL: 60, StaleIfError: 60}, want: true},
+		{name: "stale beyond stale-if-error", value: CacheValue{Timestamp: now - 121, TTL: 60, StaleIfError: 60}, want: false},
+		{name: "zero ttl within stale-if-error", value: CacheValue{Timestamp: now - 10, TTL: 0, StaleIfError: 60}, want: true},
+		{name: "zero ttl without stale-if-error", value: CacheValue{Timestamp: now - 10, TTL: 0}, want: false},
+		{name: "soft purged within stale-if-error", value: CacheValue{Timestamp: now - 100, TTL: 3600, StaleIfError: 60, Purged: now - 30}, want: true},
+		{name: "soft purged beyond stale-if-error", value: CacheValue{Timestamp: now - 100, TTL: 3600, StaleIfError: 60, Purged: now - 61}, want: false},
+		{name: "soft purged after ttl", value: CacheValue{Timestamp: now - 100, TTL: 60, StaleIfError: 60, Purged: now - 10}, want: true},
+		{name: "soft purged zero ttl", value: CacheValue{Timestamp: now - 10, TTL: 0, StaleIfError: 60, Purged: now}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.value.usableOnError(now))
+		})
+	}
+
+	// stale-while-revalidate 와 stale-if-error 중 긴 쪽만큼 더 보관한다
+	v := CacheValue{TTL: 60, StaleWhileRevalidate: 30, StaleIfError: 120}
+	assert.Equal(t, 180, v.storageTTL())
+
+	// storage_ttl 이 있으면 그대로 쓴다
+	v = CacheValue{TTL: 60, StaleWhileRevalidate: 30, StorageTTL: 3600}
+	assert.Equal(t, 3600, v.storageTTL())
+	v = CacheValue{TTL: 0, StorageTTL: 3600}
+	assert.Equal(t, 3600, v.storageTTL())
+}
+
+func TestCacheValue_replaces(t *testing.T) {
+	now := time.Now().Unix()
+	stale := &CacheValue{Timestamp: now - 100, TTL: 60}
+	purged := &CacheValue{Timestamp: now, TTL: 60, Purged: now}
+
+	tests := []struct {
+		name  string
+		value CacheValue
+		old   *CacheValue
+		want  bool
+	}{
+		{name: "miss", value: CacheValue{Timestamp: now, TTL: 60}, old: nil, want: true},
+		{name: "same stale value", value: *stale, old: stale, want: false},
+		{name: "refreshed", value: CacheValue{Timestamp: now, TTL: 60}, old: stale, want: true},
+		{name: "same soft purged value", value: *purged, old: purged, want: false},
+		{name: "refreshed in the second of soft purge", value: CacheValue{Timestamp: now, TTL: 60}, old: purged, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.value.replaces(tt.old))
+		})
+	}
+}
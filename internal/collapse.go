@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/eko/gocache/lib/v4/marshaler"
+	"github.com/redis/go-redis/v9"
+)
+
+// collapseLockPrefix 는 Redis 에 저장하는 잠금 키의 접두어다.
+const collapseLockPrefix = "sonic-boom:lock:"
+
+// 다른 노드가 값을 저장했는지 Redis 를 다시 확인하는 주기
+const collapsePollInterval = 20 * time.Millisecond
+
+// fillLock 은 같은 캐시 키에 대해 업스트림으로 가는 요청을 하나로 제한하는 잠금이다.
+// in-memory 전략은 프로세스 안에서, redis 전략은 Kong 노드 사이에서 잠근다.
+type fillLock interface {
+	// acquire 는 잠금을 얻으면 토큰을 돌려준다. 다른 요청이 잠금을 갖고 있으면 빈 문자열을 돌려준다.
+	acquire(ctx context.Context, cacheKeyID string, ttl time.Duration) (string, error)
+	// release 는 토큰이 일치할 때만 잠금을 푼다. value 는 저장된 값이며, 저장하지 않았다면 nil 이다.
+	release(ctx context.Context, cacheKeyID string, token string, value *CacheValue) error
+	// wait 는 잠금이 풀리거나 ctx 가 끝날 때까지 기다린 뒤 저장된 값을 돌려준다.
+	// stale 은 갱신을 기다리는 stale 한 값이며, 미스라면 nil 이다. 스토어에 그대로 있는 stale 은 저장된 값으로 보지 않는다.
+	wait(ctx context.Context, cacheKeyID string, marshal *marshaler.Marshaler, stale *CacheValue) *CacheValue
+}
+
+// getCacheValue 는 스토어에서 값을 읽는다. 없거나 읽지 못하면 nil 이다.
+func getCacheValue(ctx context.Context, marshal *marshaler.Marshaler, cacheKeyID string) *CacheValue {
+	cached, err := marshal.Get(ctx, cacheKeyID, new(CacheValue))
+	if cached == nil || err != nil {
+		return nil
+	}
+	return cached.(*CacheValue)
+}
+
+// memoryLock 은 프로세스 안의 잠금 하나다. 잠금이 풀리면 done 이 닫힌다.
+type memoryLock struct {
+	token     string
+	expiresAt time.Time
+	done      chan struct{}
+	once      sync.Once
+	// done 이 닫힌 뒤에만 읽는다
+	value *CacheValue
+}
+
+func (l *memoryLock) close(value *CacheValue) {
+	l.once.Do(func() {
+		l.value = value
+		close(l.done)
+	})
+}
+
+// 캐시 키별 잠금
+var memoryLocks sync.Map // map[string]*memoryLock
+
+// memoryFillLock 은 in-memory 전략의 잠금이다.
+// ristretto 는 비동기로 저장하므로, 기다리던 요청에는 스토어 대신 잠금을 통해 값을 넘긴다.
+type memoryFillLock struct{}
+
+func (memoryFillLock) acquire(_ context.Context, cacheKeyID string, ttl time.Duration) (string, error) {
+	lock := &memoryLock{
+		token:     newRandomToken(),
+		expiresAt: time.Now().Add(ttl),
+		done:      make(chan struct{}),
+	}
+	for {
+		actual, loaded := memoryLocks.LoadOrStore(cacheKeyID, lock)
+		if !loaded {
+			return lock.token, nil
+		}
+
+		held := actual.(*memoryLock)
+		if time.Now().Before(held.expiresAt) {
+			return "", nil
+		}
+		// 만료된 잠금은 치우고 다시 시도한다
+		if memoryLocks.CompareAndDelete(cacheKeyID, held) {
+			held.close(nil)
+		}
+	}
+}
+
+func (memoryFillLock) release(_ context.Context, cacheKeyID string, token string, value *CacheValue) error {
+	actual, ok := memoryLocks.Load(cacheKeyID)
+	if !ok {
+		return nil
+	}
+
+	held := actual.(*memoryLock)
+	if held.token != token {
+		return nil
+	}
+	if memoryLocks.CompareAndDelete(cacheKeyID, held) {
+		held.close(value)
+	}
+	return nil
+}
+
+func (memoryFillLock) wait(ctx context.Context, cacheKeyID string, marshal *marshaler.Marshaler, _ *CacheValue) *CacheValue {
+	actual, ok := memoryLocks.Load(cacheKeyID)
+	if !ok {
+		// 그 사이에 잠금이 풀렸다
+		return getCacheValue(ctx, marshal, cacheKeyID)
+	}
+
+	held := actual.(*memoryLock)
+	select {
+	case <-held.done:
+		if held.value == nil {
+			return nil
+		}
+		// 기다리던 요청들이 응답 헤더를 고치므로 복사해서 넘긴다
+		return held.value.clone()
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// 잠금을 가진 요청일 때만 지운다
+var redisReleaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// redisFillLock 은 redis, redis-cluster 전략의 잠금이다. SET NX PX 로 잠그고, 기다리는 동안 스토어를 폴링한다.
+type redisFillLock struct {
+	client redis.UniversalClient
+}
+
+func (l *redisFillLock) acquire(ctx context.Context, cacheKeyID string, ttl time.Duration) (string, error) {
+	token := newRandomToken()
+	ok, err := l.client.SetNX(ctx, collapseLockPrefix+cacheKeyID, token, ttl).Result()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", nil
+	}
+	return token, nil
+}
+
+func (l *redisFillLock) release(ctx context.Context, cacheKeyID string, token string, _ *CacheValue) error {
+	return redisReleaseScript.Run(ctx, l.client, []string{collapseLockPrefix + cacheKeyID}, token).Err()
+}
+
+func (l *redisFillLock) wait(ctx context.Context, cacheKeyID string, marshal *marshaler.Marshaler, stale *CacheValue) *CacheValue {
+	ticker := time.NewTicker(collapsePollInterval)
+	defer ticker.Stop()
+
+	for {
+		if value := getCacheValue(ctx, marshal, cacheKeyID); value != nil && value.replaces(stale) {
+			return value
+		}
+
+		n, err := l.client.Exists(ctx, collapseLockPrefix+cacheKeyID).Result()
+		if err != nil || n == 0 {
+			// 잠금이 풀렸는데 값이 없다면 저장하지 못한 것이다
+			return getCacheValue(ctx, marshal, cacheKeyID)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// collapseEnabled 는 같은 캐시 키의 미스를 하나로 모을지 알려준다.
+func (conf *Config) collapseEnabled() bool {
+	return conf.CollapseTimeoutMs > 0
+}
+
+func (conf *Config) collapseTimeout() time.Duration {
+	return time.Duration(conf.CollapseTimeoutMs) * time.Millisecond
+}
+
+func (conf *Config) newFillLock() (fillLock, error) {
+	if conf.Strategy == "in-memory" {
+		return memoryFillLock{}, nil
+	}
+
+	client, err := conf.newRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	return &redisFillLock{client: client}, nil
+}
+
+// collapse 는 캐시 미스가 났거나 stale 한 값을 갱신해야 하는 요청들 가운데 하나만 업스트림으로 보낸다.
+// stale 은 갱신할 stale 한 값이며, 미스라면 nil 이다.
+//
+//   - 잠금을 얻은 요청은 토큰을 받는다. Response 에서 값을 저장한 뒤 잠금을 푼다
+//   - 나머지 요청은 collapse_timeout_ms 동안 저장된 값을 기다렸다가 돌려받는다
+//
+// 둘 다 비어 있으면 잠금 없이 업스트림으로 보낸다.
+func (conf *Config) collapse(marshal *marshaler.Marshaler, cacheKeyID string, stale *CacheValue) (string, *CacheValue) {
+	logger := conf.logger
+
+	lock, err := conf.newFillLock()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create fill lock")
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.collapseTimeout())
+	defer cancel()
+
+	token, err := lock.acquire(ctx, cacheKeyID, conf.collapseTimeout())
+	if err != nil {
+		logger.Error().Err(err).Msgf("Failed to acquire fill lock for cache key '%s'", cacheKeyID)
+		return "", nil
+	}
+	if token != "" {
+		logger.Debug().Msgf("Fill lock for cache key '%s' is acquired", cacheKeyID)
+		return token, nil
+	}
+
+	logger.Debug().Msgf("Waiting for cache key '%s' to be filled", cacheKeyID)
+	value := lock.wait(ctx, cacheKeyID, marshal, stale)
+	if value == nil {
+		logger.Debug().Msgf("Cache key '%s' is not filled in %s", cacheKeyID, conf.collapseTimeout())
+	}
+	return "", value
+}
+
+// releaseCollapse 는 collapse 로 얻은 잠금을 푼다. value 는 저장한 값이며, 저장하지 않았다면 nil 이다.
+func (conf *Config) releaseCollapse(cacheKeyID string, token string, value *CacheValue) {
+	lock, err := conf.newFillLock()
+	if err != nil {
+		conf.logger.Error().Err(err).Msg("Failed to create fill lock")
+		return
+	}
+
+	if err := lock.release(context.Background(), cacheKeyID, token, value); err != nil {
+		conf.logger.Error().Err(err).Msgf("Failed to release fill lock for cache key '%s'", cacheKeyID)
+	}
+}
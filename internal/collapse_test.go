@@ -0,0 +1,344 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisConfigForTest(t *testing.T) (*Config, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	port, err := strconv.Atoi(server.Port())
+	require.NoError(t, err)
+
+	cfg := configDefault()
+	cfg.Redis.Host = server.Host()
+	cfg.Redis.Port = port
+	cfg.CollapseTimeoutMs = 500
+	cfg.logger = NewLogger(&cfg.LogConf)
+	t.Cleanup(cfg.logger.Close)
+	return cfg, server
+}
+
+func TestMemoryFillLock(t *testing.T) {
+	_, marshal, err := newInMemoryConfigForTest().newCacheManager(60)
+	require.NoError(t, err)
+
+	lock := memoryFillLock{}
+	ctx := context.Background()
+	cacheKeyID := "memory-fill-lock"
+
+	token, err := lock.acquire(ctx, cacheKeyID, time.Second)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+
+	// 잠금을 가진 요청이 있으면 얻지 못한다
+	other, err := lock.acquire(ctx, cacheKeyID, time.Second)
+	require.NoError(t, err)
+	assert.Empty(t, other)
+
+	value := &CacheValue{Status: 200, Headers: map[string][]string{"Content-Type": {"application/json"}}, Body: []byte("{}")}
+	got := make(chan *CacheValue, 1)
+	go func() {
+		got <- lock.wait(ctx, cacheKeyID, marshal, nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// 토큰이 다르면 풀리지 않는다
+	require.NoError(t, lock.release(ctx, cacheKeyID, "wrong-token", nil))
+	_, held := memoryLocks.Load(cacheKeyID)
+	assert.True(t, held)
+
+	require.NoError(t, lock.release(ctx, cacheKeyID, token, value))
+	select {
+	case v := <-got:
+		require.NotNil(t, v)
+		assert.Equal(t, value.Body, v.Body)
+		assert.Equal(t, value.Headers, v.Headers)
+		// 기다린 요청은 복사본을 받는다
+		v.Headers["X-Cache-Status"] = []string{"Hit"}
+		assert.NotContains(t, value.Headers, "X-Cache-Status")
+	case <-time.After(time.Second):
+		t.Fatal("waiter is not woken up")
+	}
+
+	_, held = memoryLocks.Load(cacheKeyID)
+	assert.False(t, held)
+}
+
+func TestMemoryFillLock_Expired(t *testing.T) {
+	lock := memoryFillLock{}
+	ctx := context.Background()
+	cacheKeyID := "memory-fill-lock-expired"
+
+	token, err := lock.acquire(ctx, cacheKeyID, time.Millisecond)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	time.Sleep(5 * time.Millisecond)
+
+	// 만료된 잠금은 다른 요청이 가져간다
+	next, err := lock.acquire(ctx, cacheKeyID, time.Second)
+	require.NoError(t, err)
+	require.NotEmpty(t, next)
+	assert.NotEqual(t, token, next)
+
+	// 만료된 잠금의 토큰으로는 새 잠금을 풀지 못한다
+	require.NoError(t, lock.release(ctx, cacheKeyID, token, nil))
+	_, held := memoryLocks.Load(cacheKeyID)
+	assert.True(t, held)
+
+	// 기다리다 시간이 지나면 nil 을 돌려준다
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, marshal, err := newInMemoryConfigForTest().newCacheManager(60)
+	require.NoError(t, err)
+	assert.Nil(t, lock.wait(waitCtx, cacheKeyID, marshal, nil))
+
+	require.NoError(t, lock.release(ctx, cacheKeyID, next, nil))
+}
+
+func TestRedisFillLock(t *testing.T) {
+	cfg, server := newRedisConfigForTest(t)
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+
+	l, err := cfg.newFillLock()
+	require.NoError(t, err)
+	lock := l.(*redisFillLock)
+
+	ctx := context.Background()
+	cacheKeyID := "redis-fill-lock"
+
+	token, err := lock.acquire(ctx, cacheKeyID, time.Second)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	assert.True(t, server.Exists(collapseLockPrefix+cacheKeyID))
+
+	other, err := lock.acquire(ctx, cacheKeyID, time.Second)
+	require.NoError(t, err)
+	assert.Empty(t, other)
+
+	// 토큰이 다르면 풀리지 않는다
+	require.NoError(t, lock.release(ctx, cacheKeyID, "wrong-token", nil))
+	assert.True(t, server.Exists(collapseLockPrefix+cacheKeyID))
+
+	got := make(chan *CacheValue, 1)
+	go func() {
+		got <- lock.wait(ctx, cacheKeyID, marshal, nil)
+	}()
+
+	// 다른 노드가 값을 저장하고 잠금을 푼다
+	require.NoError(t, marshal.Set(ctx, cacheKeyID, &CacheValue{Status: 200, Body: []byte("{}"), TTL: 60}))
+	require.NoError(t, lock.release(ctx, cacheKeyID, token, nil))
+	assert.False(t, server.Exists(collapseLockPrefix+cacheKeyID))
+
+	select {
+	case v := <-got:
+		require.NotNil(t, v)
+		assert.Equal(t, []byte("{}"), v.Body)
+	case <-time.After(time.Second):
+		t.Fatal("waiter is not woken up")
+	}
+}
+
+func TestRedisFillLock_ReleasedWithoutValue(t *testing.T) {
+	cfg, _ := newRedisConfigForTest(t)
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+
+	lock, err := cfg.newFillLock()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cacheKeyID := "redis-fill-lock-without-value"
+
+	token, err := lock.acquire(ctx, cacheKeyID, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, lock.release(ctx, cacheKeyID, token, nil))
+
+	// 값을 저장하지 못하고 잠금이 풀렸다면 기다리지 않는다
+	started := time.Now()
+	assert.Nil(t, lock.wait(ctx, cacheKeyID, marshal, nil))
+	assert.Less(t, time.Since(started), 500*time.Millisecond)
+}
+
+func TestRedisFillLock_Stale(t *testing.T) {
+	cfg, _ := newRedisConfigForTest(t)
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+
+	lock, err := cfg.newFillLock()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cacheKeyID := "redis-fill-lock-stale"
+
+	stale := &CacheValue{Status: 200, Body: []byte(`{"stale":true}`), Timestamp: time.Now().Unix() - 100, TTL: 60}
+	require.NoError(t, marshal.Set(ctx, cacheKeyID, stale))
+	token, err := lock.acquire(ctx, cacheKeyID, time.Second)
+	require.NoError(t, err)
+
+	got := make(chan *CacheValue, 1)
+	go func() {
+		got <- lock.wait(ctx, cacheKeyID, marshal, stale)
+	}()
+
+	// 스토어에 있는 stale 한 값은 저장된 값으로 보지 않고 기다린다
+	select {
+	case <-got:
+		t.Fatal("waiter returns the stale value")
+	case <-time.After(3 * collapsePollInterval):
+	}
+
+	require.NoError(t, marshal.Set(ctx, cacheKeyID, &CacheValue{Status: 200, Body: []byte(`{"stale":false}`), Timestamp: time.Now().Unix(), TTL: 60}))
+	require.NoError(t, lock.release(ctx, cacheKeyID, token, nil))
+	select {
+	case v := <-got:
+		require.NotNil(t, v)
+		assert.Equal(t, `{"stale":false}`, string(v.Body))
+	case <-time.After(time.Second):
+		t.Fatal("waiter is not woken up")
+	}
+}
+
+func TestConfig_collapse(t *testing.T) {
+	cfg, _ := newRedisConfigForTest(t)
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+	cacheKeyID := "collapse"
+
+	// 첫 요청은 잠금을 얻는다
+	token, value := cfg.collapse(marshal, cacheKeyID, nil)
+	require.NotEmpty(t, token)
+	assert.Nil(t, value)
+
+	// 나머지는 기다리다 시간이 지나면 잠금 없이 업스트림으로 간다
+	cfg.CollapseTimeoutMs = 50
+	other, value := cfg.collapse(marshal, cacheKeyID, nil)
+	assert.Empty(t, other)
+	assert.Nil(t, value)
+
+	cfg.releaseCollapse(cacheKeyID, token, nil)
+	token, _ = cfg.collapse(marshal, cacheKeyID, nil)
+	assert.NotEmpty(t, token)
+}
+
+func Test_Access_InMemory_Collapse(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	cfg.CacheControl = true
+	cfg.CacheTTL = 60
+	cfg.CacheVersion = Version
+
+	// 캐시 키는 잠그지 않는 설정값으로 구한다. 플러그인 인스턴스의 Config 는 처음 쓴 뒤 고치지 않는다
+	path := "/access/collapse"
+	keyCfg := *cfg
+	cacheKeyID := cacheKeyForTest(t, &keyCfg, path)
+	cfg.CollapseTimeoutMs = 1000
+
+	// 다른 요청이 업스트림에서 값을 가져오는 중이다
+	token, err := memoryFillLock{}.acquire(context.Background(), cacheKeyID, time.Second)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+
+	steps := append(accessLookupSteps(path, ""), accessHitSteps(t, nil)...)
+	kong, recorder := mockPdkSteps(t, steps)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cfg.Access(kong)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, memoryFillLock{}.release(context.Background(), cacheKeyID, token, &CacheValue{
+		Status:    http.StatusOK,
+		Headers:   map[string][]string{"Content-Type": {"application/json"}},
+		Body:      []byte(`{"collapsed":true}`),
+		BodyLen:   18,
+		Timestamp: time.Now().Unix(),
+		TTL:       60,
+		Version:   Version,
+	}))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Access is not finished")
+	}
+	recorder.assertConsumed()
+
+	// 기다린 요청은 업스트림에 가지 않고 저장된 값으로 응답한다
+	exit := recorder.exitArgs()
+	assert.Equal(t, int32(http.StatusOK), exit.Status)
+	assert.Equal(t, `{"collapsed":true}`, string(exit.Body))
+	assert.Equal(t, []string{"Hit"}, bridge.UnwrapHeaders(exit.Headers)["X-Cache-Status"])
+}
+
+// stale 한 값을 갱신하는 요청이 있으면 업스트림에 가지 않고 그 요청이 저장한 값으로 응답한다
+func Test_Access_InMemory_StaleCollapse(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	isolateInMemoryForTest(t, cfg)
+	cfg.CacheControl = true
+	cfg.CacheTTL = 60
+	cfg.CacheVersion = Version
+
+	path := "/access/stale-collapse"
+	keyCfg := *cfg
+	cacheKeyID := cacheKeyForTest(t, &keyCfg, path)
+	seedInMemory(t, &keyCfg, cacheKeyID, &CacheValue{
+		Status:     http.StatusOK,
+		Headers:    map[string][]string{"Content-Type": {"application/json"}},
+		Body:       []byte(`{"stale":true}`),
+		BodyLen:    14,
+		Timestamp:  time.Now().Unix() - 70,
+		TTL:        60,
+		Version:    Version,
+		StorageTTL: 600,
+	})
+	cfg.CollapseTimeoutMs = 1000
+
+	token, err := memoryFillLock{}.acquire(context.Background(), cacheKeyID, time.Second)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+
+	steps := append(accessLookupSteps(path, ""), accessHitSteps(t, nil)...)
+	kong, recorder := mockPdkSteps(t, steps)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cfg.Access(kong)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, memoryFillLock{}.release(context.Background(), cacheKeyID, token, &CacheValue{
+		Status:    http.StatusOK,
+		Headers:   map[string][]string{"Content-Type": {"application/json"}},
+		Body:      []byte(`{"stale":false}`),
+		BodyLen:   15,
+		Timestamp: time.Now().Unix(),
+		TTL:       60,
+		Version:   Version,
+	}))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Access is not finished")
+	}
+	recorder.assertConsumed()
+
+	exit := recorder.exitArgs()
+	assert.Equal(t, int32(http.StatusOK), exit.Status)
+	assert.Equal(t, `{"stale":false}`, string(exit.Body))
+	assert.Equal(t, []string{"Hit"}, bridge.UnwrapHeaders(exit.Headers)["X-Cache-Status"])
+}
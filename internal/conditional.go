@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Kong/go-pdk"
+	lib_store "github.com/eko/gocache/lib/v4/store"
+)
+
+// conditionalRequest 는 캐시된 값으로 평가할 요청의 조건부 헤더다.
+//
+// See https://www.rfc-editor.org/rfc/rfc9110#section-13
+type conditionalRequest struct {
+	Method            string
+	IfMatch           string
+	IfNoneMatch       string
+	IfModifiedSince   string
+	IfUnmodifiedSince string
+}
+
+func (conf *Config) conditionalRequest(kong *pdk.PDK, method string) conditionalRequest {
+	req := conditionalRequest{Method: method}
+
+	headers, err := kong.Request.GetHeaders(1000)
+	if err != nil {
+		conf.logger.Debug().Err(err).Msg("Failed to get request headers")
+		return req
+	}
+	req.IfMatch = headerValue(headers, "If-Match")
+	req.IfNoneMatch = headerValue(headers, "If-None-Match")
+	req.IfModifiedSince = headerValue(headers, "If-Modified-Since")
+	req.IfUnmodifiedSince = headerValue(headers, "If-Unmodified-Since")
+	return req
+}
+
+// headerValue 는 대소문자를 구분하지 않고 헤더의 첫 번째 값을 찾는다.
+func headerValue(headers map[string][]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// lastModified 는 캐시된 응답의 Last-Modified 다. 업스트림이 보내지 않았다면 저장한 시각을 쓴다.
+func lastModified(cacheValue *CacheValue) time.Time {
+	if v := headerValue(cacheValue.Headers, "Last-Modified"); v != "" {
+		if t, err := http.ParseTime(v); err == nil {
+			return t
+		}
+	}
+	return time.Unix(cacheValue.Timestamp, 0)
+}
+
+// evaluatePreconditions 는 캐시된 값으로 요청의 조건부 헤더를 평가한다.
+// 조건이 맞으면 0 을, 아니면 대신 응답할 상태 코드(304, 412)를 돌려준다.
+//
+// See https://www.rfc-editor.org/rfc/rfc9110#section-13.2.2
+func evaluatePreconditions(req conditionalRequest, cacheValue *CacheValue) int {
+	// 2xx 가 아닌 응답에는 조건부 헤더를 적용하지 않는다
+	if cacheValue.Status < http.StatusOK || cacheValue.Status >= http.StatusMultipleChoices {
+		return 0
+	}
+
+	etag := headerValue(cacheValue.Headers, "ETag")
+	getOrHead := req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	if req.IfMatch != "" {
+		if !etagMatches(req.IfMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if req.IfUnmodifiedSince != "" {
+		if since, err := http.ParseTime(req.IfUnmodifiedSince); err == nil && lastModified(cacheValue).After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if req.IfNoneMatch != "" {
+		if etagMatches(req.IfNoneMatch, etag, false) {
+			if getOrHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if req.IfModifiedSince != "" && getOrHead {
+		if since, err := http.ParseTime(req.IfModifiedSince); err == nil && !lastModified(cacheValue).After(since) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// etagMatches 는 If-Match, If-None-Match 헤더의 ETag 목록에 etag 가 있는지 확인한다.
+// strong 이 false 이면 W/ 접두어를 무시하는 약한 비교를 한다.
+//
+// See https://www.rfc-editor.org/rfc/rfc9110#section-8.8.3.2
+func etagMatches(header string, etag string, strong bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return etag != ""
+	}
+	if etag == "" {
+		return false
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+
+	for _, candidate := range parseETags(header) {
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseETags 는 쉼표로 구분된 ETag 목록을 나눈다. 따옴표 안의 쉼표는 구분자로 보지 않는다.
+func parseETags(header string) []string {
+	var etags []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				if etag := strings.TrimSpace(header[start:i]); etag != "" {
+					etags = append(etags, etag)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if etag := strings.TrimSpace(header[start:]); etag != "" {
+		etags = append(etags, etag)
+	}
+	return etags
+}
+
+// setHeaderValues 는 대소문자를 구분하지 않고 같은 이름의 헤더를 지운 뒤 값을 설정한다.
+func setHeaderValues(headers map[string][]string, name string, values []string) {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			delete(headers, k)
+		}
+	}
+	headers[name] = values
+}
+
+// addRevalidationHeaders 는 stale 한 값의 검증자(ETag, Last-Modified)로 업스트림 요청에 조건부 헤더를 붙인다.
+// 클라이언트가 보낸 조건부 헤더가 섞이지 않도록, 검증자가 없는 쪽 헤더는 지운다.
+// 검증자가 하나도 없으면 아무 것도 하지 않고 false 를 돌려준다.
+func (conf *Config) addRevalidationHeaders(kong *pdk.PDK, cacheValue *CacheValue) bool {
+	validators := []struct {
+		header string
+		value  string
+	}{
+		{header: "If-None-Match", value: headerValue(cacheValue.Headers, "ETag")},
+		{header: "If-Modified-Since", value: headerValue(cacheValue.Headers, "Last-Modified")},
+	}
+	if validators[0].value == "" && validators[1].value == "" {
+		return false
+	}
+
+	for _, v := range validators {
+		var err error
+		if v.value == "" {
+			err = kong.ServiceRequest.ClearHeader(v.header)
+		} else {
+			err = kong.ServiceRequest.SetHeader(v.header, v.value)
+		}
+		if err != nil {
+			conf.logger.Warn().Err(err).Msgf("Failed to set upstream header `%s`", v.header)
+			return false
+		}
+	}
+	return true
+}
+
+// 업스트림의 304 응답으로 갱신할 헤더
+//
+// See https://www.rfc-editor.org/rfc/rfc9111#section-4.3.4
+var notModifiedHeaders = []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Date", "Vary"}
+
+// refreshNotModified 는 조건부 요청에 업스트림이 304 로 응답했을 때, 저장된 값의 헤더와 Timestamp 를 갱신하고 그 값으로 응답한다.
+// 본문은 다시 받지 않는다. 저장한 값을 돌려주며, 저장하지 못했다면 nil 이다.
+func (conf *Config) refreshNotModified(kong *pdk.PDK, signal CacheSignal) *CacheValue {
+	logger := conf.logger
+
+	_, marshal, err := conf.newCacheManager(signal.CacheTTL)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create cache manager")
+		return nil
+	}
+
+	cacheKeyID := signal.CacheKeyID
+	cacheValue := getCacheValue(context.Background(), marshal, cacheKeyID)
+	if cacheValue == nil || cacheValue.Version != conf.CacheVersion {
+		// 304 를 클라이언트에 그대로 넘기면 본문이 없는 응답이 된다
+		logger.Error().Msgf("Cache key '%s' is gone while revalidating", cacheKeyID)
+		kong.Response.Exit(http.StatusBadGateway, nil, map[string][]string{"X-Cache-Status": {"Bypass"}})
+		return nil
+	}
+
+	headers, err := kong.Response.GetHeaders(1000)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Getting response headers failed")
+	}
+	if cacheValue.Headers == nil {
+		cacheValue.Headers = map[string][]string{}
+	}
+	for _, name := range notModifiedHeaders {
+		for k, v := range headers {
+			if strings.EqualFold(k, name) && len(v) > 0 {
+				setHeaderValues(cacheValue.Headers, name, v)
+			}
+		}
+	}
+
+	now := time.Now()
+	secs := now.Unix()
+	cacheValue.Timestamp = secs
+	cacheValue.TTL = int64(signal.CacheTTL)
+	cacheValue.Purged = 0
+	if conf.CacheControl {
+		resCC := parseCacheControl(headerValue(cacheValue.Headers, "Cache-Control"))
+		cacheValue.TTL = int64(resourceTTL(resCC, headerValue(cacheValue.Headers, "Expires"), now))
+	}
+
+	// 응답 헤더를 만들며 cacheValue 를 고치므로 저장한 값은 복사해 둔다
+	var stored *CacheValue
+	storageTTL := cacheValue.storageTTL()
+	if err := marshal.Set(context.Background(), cacheKeyID, cacheValue, lib_store.WithExpiration(time.Duration(storageTTL)*time.Second)); err != nil {
+		logger.Error().Err(err).Msg("Cache set failed")
+	} else {
+		logger.Debug().Msgf("Cache key '%s' is refreshed by 304", cacheKeyID)
+		stored = cacheValue.clone()
+	}
+
+	method, err := kong.Request.GetMethod()
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to get request method")
+	}
+
+	// 업스트림에는 플러그인의 검증자를 보냈으므로, 클라이언트의 조건부 헤더는 여기서 평가한다
+	respHeaders := cacheValue.responseHeaders("Revalidated", secs)
+	switch status := evaluatePreconditions(conf.conditionalRequest(kong, method), cacheValue); status {
+	case http.StatusNotModified:
+		kong.Response.Exit(status, nil, respHeaders)
+	case http.StatusPreconditionFailed:
+		kong.Response.Exit(status, nil, map[string][]string{"X-Cache-Status": {"Revalidated"}})
+	default:
+		kong.Response.Exit(cacheValue.Status, cacheValue.Body, respHeaders)
+	}
+	return stored
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseETags(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{name: "empty", header: "", want: nil},
+		{name: "single", header: `"abc"`, want: []string{`"abc"`}},
+		{name: "list with weak tag", header: `"abc", W/"def" ,"ghi"`, want: []string{`"abc"`, `W/"def"`, `"ghi"`}},
+		{name: "comma in quotes", header: `"a,b", "c"`, want: []string{`"a,b"`, `"c"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseETags(tt.header))
+		})
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		strong bool
+		want   bool
+	}{
+		{name: "same tag", header: `"abc"`, etag: `"abc"`, want: true},
+		{name: "one of list", header: `"x", "abc"`, etag: `"abc"`, want: true},
+		{name: "different tag", header: `"x"`, etag: `"abc"`, want: false},
+		{name: "weak comparison ignores W/", header: `W/"abc"`, etag: `"abc"`, want: true},
+		{name: "strong comparison rejects weak tag", header: `W/"abc"`, etag: `"abc"`, strong: true, want: false},
+		{name: "strong comparison rejects weak etag", header: `"abc"`, etag: `W/"abc"`, strong: true, want: false},
+		{name: "wildcard", header: "*", etag: `"abc"`, want: true},
+		{name: "wildcard without etag", header: "*", etag: "", want: false},
+		{name: "no etag", header: `"abc"`, etag: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, etagMatches(tt.header, tt.etag, tt.strong))
+		})
+	}
+}
+
+func TestEvaluatePreconditions(t *testing.T) {
+	modified := time.Date(2025, 2, 19, 12, 0, 0, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	cacheValue := &CacheValue{
+		Status: http.StatusOK,
+		Headers: map[string][]string{
+			"etag":          {`"v1"`},
+			"last-modified": {modified.Format(http.TimeFormat)},
+		},
+		Timestamp: modified.Add(time.Minute).Unix(),
+	}
+
+	tests := []struct {
+		name       string
+		req        conditionalRequest
+		cacheValue *CacheValue
+		want       int
+	}{
+		{
+			name: "no conditional headers",
+			req:  conditionalRequest{Method: http.MethodGet},
+			want: 0,
+		},
+		{
+			name: "If-None-Match matches",
+			req:  conditionalRequest{Method: http.MethodGet, IfNoneMatch: `"v1"`},
+			want: http.StatusNotModified,
+		},
+		{
+			name: "If-None-Match matches on HEAD",
+			req:  conditionalRequest{Method: http.MethodHead, IfNoneMatch: `W/"v1"`},
+			want: http.StatusNotModified,
+		},
+		{
+			name: "If-None-Match matches on POST",
+			req:  conditionalRequest{Method: http.MethodPost, IfNoneMatch: `"v1"`},
+			want: http.StatusPreconditionFailed,
+		},
+		{
+			name: "If-None-Match does not match",
+			req:  conditionalRequest{Method: http.MethodGet, IfNoneMatch: `"v0"`},
+			want: 0,
+		},
+		{
+			name:       "non 2xx response is not evaluated",
+			req:        conditionalRequest{Method: http.MethodGet, IfNoneMatch: `"v1"`},
+			cacheValue: &CacheValue{Status: http.StatusNotFound, Headers: map[string][]string{"etag": {`"v1"`}}},
+			want:       0,
+		},
+		{
+			name: "If-Modified-Since not modified",
+			req:  conditionalRequest{Method: http.MethodGet, IfModifiedSince: modified.Format(http.TimeFormat)},
+			want: http.StatusNotModified,
+		},
+		{
+			name: "If-Modified-Since modified",
+			req:  conditionalRequest{Method: http.MethodGet, IfModifiedSince: before},
+			want: 0,
+		},
+		{
+			name: "If-Modified-Since is ignored with If-None-Match",
+			req:  conditionalRequest{Method: http.MethodGet, IfNoneMatch: `"v0"`, IfModifiedSince: after},
+			want: 0,
+		},
+		{
+			name: "invalid If-Modified-Since is ignored",
+			req:  conditionalRequest{Method: http.MethodGet, IfModifiedSince: "yesterday"},
+			want: 0,
+		},
+		{
+			name:       "If-Modified-Since falls back to the stored timestamp",
+			req:        conditionalRequest{Method: http.MethodGet, IfModifiedSince: after},
+			cacheValue: &CacheValue{Status: http.StatusOK, Timestamp: modified.Unix()},
+			want:       http.StatusNotModified,
+		},
+		{
+			name:       "If-Modified-Since before the stored timestamp",
+			req:        conditionalRequest{Method: http.MethodGet, IfModifiedSince: before},
+			cacheValue: &CacheValue{Status: http.StatusOK, Timestamp: modified.Unix()},
+			want:       0,
+		},
+		{
+			name: "If-Match matches",
+			req:  conditionalRequest{Method: http.MethodGet, IfMatch: `"v1"`},
+			want: 0,
+		},
+		{
+			name: "If-Match does not match",
+			req:  conditionalRequest{Method: http.MethodGet, IfMatch: `"v0"`},
+			want: http.StatusPreconditionFailed,
+		},
+		{
+			name: "If-Match uses strong comparison",
+			req:  conditionalRequest{Method: http.MethodGet, IfMatch: `W/"v1"`},
+			want: http.StatusPreconditionFailed,
+		},
+		{
+			name: "If-Unmodified-Since not modified",
+			req:  conditionalRequest{Method: http.MethodGet, IfUnmodifiedSince: after},
+			want: 0,
+		},
+		{
+			name: "If-Unmodified-Since modified",
+			req:  conditionalRequest{Method: http.MethodGet, IfUnmodifiedSince: before},
+			want: http.StatusPreconditionFailed,
+		},
+		{
+			name: "If-Unmodified-Since is ignored with If-Match",
+			req:  conditionalRequest{Method: http.MethodGet, IfMatch: `"v1"`, IfUnmodifiedSince: before},
+			want: 0,
+		},
+		{
+			name: "If-Match is evaluated before If-None-Match",
+			req:  conditionalRequest{Method: http.MethodGet, IfMatch: `"v0"`, IfNoneMatch: `"v1"`},
+			want: http.StatusPreconditionFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.cacheValue
+			if v == nil {
+				v = cacheValue
+			}
+			assert.Equal(t, tt.want, evaluatePreconditions(tt.req, v))
+		})
+	}
+}
+
+func TestSetHeaderValues(t *testing.T) {
+	headers := map[string][]string{"etag": {`"v1"`}, "Content-Type": {"application/json"}}
+	setHeaderValues(headers, "ETag", []string{`"v2"`})
+
+	assert.Equal(t, map[string][]string{"ETag": {`"v2"`}, "Content-Type": {"application/json"}}, headers)
+}
@@ -0,0 +1,380 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/Kong/go-pdk"
+	"github.com/eko/gocache/lib/v4/marshaler"
+	lib_store "github.com/eko/gocache/lib/v4/store"
+	"github.com/pkg/errors"
+)
+
+const (
+	// persistedQueryPrefix 는 APQ 해시별 연산 종류를 기록하는 스토어 키의 접두어다.
+	persistedQueryPrefix = "sonic-boom:apq:"
+	// persistedQueryTTL 은 APQ 해시의 연산 종류를 기억하는 기간이다.
+	persistedQueryTTL = 24 * time.Hour
+
+	graphQLQuery        = "query"
+	graphQLMutation     = "mutation"
+	graphQLSubscription = "subscription"
+)
+
+// graphQLOperation 은 GraphQL 요청에서 실행할 연산이다.
+type graphQLOperation struct {
+	// Type 은 query, mutation, subscription 가운데 하나다. 해시만 보낸 APQ 요청이면 비어 있다
+	Type string
+	// Name 은 operationName 이다. 없으면 문서에서 고른 연산의 이름이다
+	Name string
+	// Query 는 공백, 쉼표와 주석을 없앤 문서다
+	Query string
+	// Variables 는 키를 정렬해 인코딩할 수 있게 json.Number 로 디코딩한 값이다
+	Variables any
+	// PersistedQueryHash 는 extensions.persistedQuery.sha256Hash 다
+	PersistedQueryHash string
+}
+
+// graphQLRequestBody 는 application/json 으로 보낸 GraphQL 요청이다.
+//
+// See https://graphql.github.io/graphql-over-http/draft/#sec-Request-Parameters
+type graphQLRequestBody struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+	Extensions    struct {
+		PersistedQuery struct {
+			Sha256Hash string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// cacheKeyBody 는 캐시 키에 넣을 본문이다. APQ 해시가 있으면 문서 대신 해시를 넣어
+// 해시만 보낸 요청과 문서까지 보낸 요청이 같은 키가 된다.
+func (o *graphQLOperation) cacheKeyBody() ([]byte, error) {
+	document := o.Query
+	if o.PersistedQueryHash != "" {
+		document = "sha256:" + o.PersistedQueryHash
+	}
+	return json.Marshal([]any{o.Name, document, o.Variables})
+}
+
+// graphQLOperationName 은 Filter 의 operation_name 규칙에 맞출 이름이다.
+func graphQLOperationName(o *graphQLOperation) string {
+	if o == nil {
+		return ""
+	}
+	return o.Name
+}
+
+// graphQLOperation 은 graphql 모드에서 요청 본문의 GraphQL 연산을 해석한다. 본문이 없는 GET 요청은 쿼리 인자에서 해석한다.
+// GraphQL 요청이 아니면 nil 을 돌려주며, mutation 과 subscription 은 캐시하지 않도록 에러를 돌려준다.
+func (conf *Config) graphQLOperation(kong *pdk.PDK, method string, body []byte) (*graphQLOperation, error) {
+	var operation *graphQLOperation
+	if len(body) == 0 {
+		if method != "GET" {
+			return nil, nil
+		}
+		args, err := kong.Request.GetQuery(1000)
+		if err != nil {
+			conf.logger.Debug().Err(err).Msg("Getting queryArgs has failed")
+			return nil, nil
+		}
+		if len(args["query"]) == 0 && len(args["extensions"]) == 0 {
+			return nil, nil
+		}
+		if operation, err = parseGraphQLQueryArgs(args); err != nil {
+			return nil, err
+		}
+	} else {
+		contentType, err := kong.Request.GetHeader("Content-Type")
+		if err != nil {
+			conf.logger.Debug().Err(err).Msg("Failed to get request header `Content-Type`")
+		}
+		if operation, err = parseGraphQLRequest(contentType, body); err != nil {
+			return nil, err
+		}
+	}
+	if operation.Type == graphQLMutation || operation.Type == graphQLSubscription {
+		return nil, fmt.Errorf("graphql %s is not cacheable", operation.Type)
+	}
+	return operation, nil
+}
+
+// parseGraphQLRequest 는 application/json 이나 application/graphql 본문을 해석한다.
+func parseGraphQLRequest(contentType string, body []byte) (*graphQLOperation, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	var req graphQLRequestBody
+	switch {
+	case mediaType == "application/graphql":
+		req.Query = string(body)
+	case isJSONContentType(contentType):
+		// 배치 요청(JSON 배열)은 여기서 에러가 된다
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, errors.Wrap(err, "invalid graphql request")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported graphql content type %q", contentType)
+	}
+	return req.operation()
+}
+
+// parseGraphQLQueryArgs 는 GET 요청의 쿼리 인자를 해석한다. variables 와 extensions 는 JSON 으로 인코딩한 값이다.
+//
+// See https://graphql.github.io/graphql-over-http/draft/#sec-GET
+func parseGraphQLQueryArgs(args map[string][]string) (*graphQLOperation, error) {
+	arg := func(name string) string {
+		if v := args[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	req := graphQLRequestBody{Query: arg("query"), OperationName: arg("operationName")}
+	if variables := arg("variables"); variables != "" {
+		req.Variables = json.RawMessage(variables)
+	}
+	if extensions := arg("extensions"); extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &req.Extensions); err != nil {
+			return nil, errors.Wrap(err, "invalid graphql extensions")
+		}
+	}
+	return req.operation()
+}
+
+// withoutGraphQLQueryArgs 는 parseGraphQLQueryArgs 가 읽는 인자를 뺀다.
+func withoutGraphQLQueryArgs(args map[string][]string) map[string][]string {
+	filtered := maps.Clone(args)
+	for _, name := range []string{"query", "operationName", "variables", "extensions"} {
+		delete(filtered, name)
+	}
+	return filtered
+}
+
+// operation 은 요청에서 실행할 연산을 해석한다.
+func (req *graphQLRequestBody) operation() (*graphQLOperation, error) {
+	operation := &graphQLOperation{
+		Name:               req.OperationName,
+		PersistedQueryHash: strings.ToLower(req.Extensions.PersistedQuery.Sha256Hash),
+	}
+
+	if len(req.Variables) > 0 {
+		variables, err := decodeJSON(req.Variables)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid graphql variables")
+		}
+		operation.Variables = variables
+	}
+
+	if req.Query == "" {
+		if operation.PersistedQueryHash == "" {
+			return nil, errors.New("graphql request has neither query nor persisted query hash")
+		}
+		return operation, nil
+	}
+
+	if operation.PersistedQueryHash != "" {
+		sum := sha256.Sum256([]byte(req.Query))
+		if hex.EncodeToString(sum[:]) != operation.PersistedQueryHash {
+			return nil, errors.New("graphql persisted query hash does not match the query")
+		}
+	}
+
+	tokens, err := lexGraphQL(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	operation.Type, operation.Name, err = selectGraphQLOperation(tokens, req.OperationName)
+	if err != nil {
+		return nil, err
+	}
+	operation.Query = joinGraphQLTokens(tokens)
+	return operation, nil
+}
+
+// cacheableGraphQLOperation 은 APQ 해시의 연산 종류를 스토어에 기록하거나 찾아본다.
+// 해시만 보낸 요청은 앞서 문서와 함께 query 로 확인된 해시일 때만 캐시한다.
+func (conf *Config) cacheableGraphQLOperation(marshal *marshaler.Marshaler, operation *graphQLOperation) bool {
+	logger := conf.logger
+
+	if operation.PersistedQueryHash == "" {
+		return operation.Type == graphQLQuery
+	}
+
+	key := persistedQueryPrefix + operation.PersistedQueryHash
+	if operation.Type != "" {
+		// 해시는 parseGraphQLRequest 에서 문서와 맞는지 확인했다
+		if err := marshal.Set(context.Background(), key, operation.Type, lib_store.WithExpiration(persistedQueryTTL)); err != nil {
+			logger.Error().Err(err).Msgf("Failed to store persisted query `%s`", operation.PersistedQueryHash)
+		}
+		return operation.Type == graphQLQuery
+	}
+
+	var operationType string
+	if _, err := marshal.Get(context.Background(), key, &operationType); err != nil {
+		logger.Debug().Err(err).Msgf("Persisted query `%s` is unknown", operation.PersistedQueryHash)
+		return false
+	}
+	operation.Type = operationType
+	return operation.Type == graphQLQuery
+}
+
+type graphQLTokenKind int
+
+const (
+	graphQLPunctuator graphQLTokenKind = iota
+	graphQLName
+	graphQLNumber
+	graphQLString
+)
+
+type graphQLToken struct {
+	kind  graphQLTokenKind
+	value string
+}
+
+// lexGraphQL 은 GraphQL 문서를 토큰으로 나눈다. 공백, 쉼표와 주석은 버린다.
+//
+// See https://spec.graphql.org/October2021/#sec-Language.Source-Text
+func lexGraphQL(query string) ([]graphQLToken, error) {
+	var tokens []graphQLToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case strings.HasPrefix(query[i:], "\ufeff"):
+			i += len("\ufeff")
+		case c == '#':
+			for i < len(query) && query[i] != '\n' && query[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "..."):
+			tokens = append(tokens, graphQLToken{kind: graphQLPunctuator, value: "..."})
+			i += 3
+		case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+			tokens = append(tokens, graphQLToken{kind: graphQLPunctuator, value: string(c)})
+			i++
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			start := i
+			for i < len(query) && (query[i] == '_' || 'a' <= query[i] && query[i] <= 'z' || 'A' <= query[i] && query[i] <= 'Z' || '0' <= query[i] && query[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, graphQLToken{kind: graphQLName, value: query[start:i]})
+		case c == '-' || '0' <= c && c <= '9':
+			start := i
+			i++
+			for i < len(query) && (strings.IndexByte("0123456789.eE+-", query[i]) >= 0) {
+				i++
+			}
+			tokens = append(tokens, graphQLToken{kind: graphQLNumber, value: query[start:i]})
+		case strings.HasPrefix(query[i:], `"""`):
+			end := i + 3
+			for {
+				next := strings.Index(query[end:], `"""`)
+				if next < 0 {
+					return nil, errors.New("graphql query has an unterminated block string")
+				}
+				end += next
+				if query[end-1] != '\\' {
+					break
+				}
+				end += 3
+			}
+			tokens = append(tokens, graphQLToken{kind: graphQLString, value: query[i : end+3]})
+			i = end + 3
+		case c == '"':
+			end := i + 1
+			for end < len(query) && query[end] != '"' && query[end] != '\n' {
+				if query[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(query) || query[end] != '"' {
+				return nil, errors.New("graphql query has an unterminated string")
+			}
+			tokens = append(tokens, graphQLToken{kind: graphQLString, value: query[i : end+1]})
+			i = end + 1
+		default:
+			return nil, fmt.Errorf("graphql query has an unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// joinGraphQLTokens 는 이름이나 숫자가 이어질 때만 공백을 넣어 토큰을 잇는다.
+func joinGraphQLTokens(tokens []graphQLToken) string {
+	var b strings.Builder
+	for i, token := range tokens {
+		if i > 0 {
+			prev := tokens[i-1]
+			if (prev.kind == graphQLName || prev.kind == graphQLNumber) && (token.kind == graphQLName || token.kind == graphQLNumber) {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(token.value)
+	}
+	return b.String()
+}
+
+// selectGraphQLOperation 은 문서에서 실행할 연산의 종류와 이름을 고른다.
+// operationName 이 없으면 문서에 연산이 하나만 있어야 한다.
+//
+// See https://spec.graphql.org/October2021/#GetOperation()
+func selectGraphQLOperation(tokens []graphQLToken, operationName string) (operationType string, name string, err error) {
+	type definition struct {
+		operationType string
+		name          string
+	}
+
+	var operations []definition
+	depth := 0
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.kind == graphQLPunctuator {
+			switch token.value {
+			case "{", "(", "[":
+				if depth == 0 && token.value == "{" && (i == 0 || tokens[i-1].value == "}") {
+					// `{ ... }` 는 이름 없는 query 다
+					operations = append(operations, definition{operationType: graphQLQuery})
+				}
+				depth++
+			case "}", ")", "]":
+				depth--
+			}
+			continue
+		}
+		if depth != 0 || token.kind != graphQLName {
+			continue
+		}
+
+		switch token.value {
+		case graphQLQuery, graphQLMutation, graphQLSubscription:
+			op := definition{operationType: token.value}
+			if i+1 < len(tokens) && tokens[i+1].kind == graphQLName {
+				op.name = tokens[i+1].value
+				i++
+			}
+			operations = append(operations, op)
+		}
+	}
+
+	for _, op := range operations {
+		if operationName == "" && len(operations) == 1 || operationName != "" && op.name == operationName {
+			return op.operationType, op.name, nil
+		}
+	}
+	if operationName == "" {
+		return "", "", fmt.Errorf("graphql query has %d operations without operationName", len(operations))
+	}
+	return "", "", fmt.Errorf("graphql query has no operation `%s`", operationName)
+}
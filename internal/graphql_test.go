@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/Kong/go-pdk/server/kong_plugin_protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestLexGraphQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "whitespace, commas and comments",
+			query: "query Q($id: ID!, $n: Int = 10) {\n  # comment\n  user(id: $id) { name, friends(first: $n) { ...F } }\n}",
+			want:  "query Q($id:ID!$n:Int=10){user(id:$id){name friends(first:$n){...F}}}",
+		},
+		{
+			name:  "strings are kept",
+			query: `{ search(text: "a,  b # c", note: """x "" y""") { id } }`,
+			want:  `{search(text:"a,  b # c"note:"""x "" y"""){id}}`,
+		},
+		{
+			name:  "escaped quotes",
+			query: `{ a(s: "say \"hi\"") }`,
+			want:  `{a(s:"say \"hi\"")}`,
+		},
+		{
+			name:  "numbers",
+			query: "{ a(x: -1.5e3 y: 2) }",
+			want:  "{a(x:-1.5e3 y:2)}",
+		},
+		{name: "unterminated string", query: `{ a(s: "x) }`, wantErr: true},
+		{name: "unterminated block string", query: `{ a(s: """x) }`, wantErr: true},
+		{name: "unexpected character", query: "{ a % b }", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexGraphQL(tt.query)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, joinGraphQLTokens(tokens))
+		})
+	}
+}
+
+func TestSelectGraphQLOperation(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		wantType      string
+		wantName      string
+		wantErr       bool
+	}{
+		{name: "shorthand query", query: "{ me { id } }", wantType: "query"},
+		{name: "named query", query: "query Me { me { id } }", wantType: "query", wantName: "Me"},
+		{name: "mutation", query: "mutation Like($id: ID!) { like(id: $id) }", wantType: "mutation", wantName: "Like"},
+		{name: "subscription", query: "subscription { onLike { id } }", wantType: "subscription"},
+		{name: "fragments are skipped", query: "fragment F on Query { me { id } } query Q { ...F }", wantType: "query", wantName: "Q"},
+		{name: "default object value", query: "query Q($f: In = {query: 1}) { a }", wantType: "query", wantName: "Q"},
+		{name: "field named mutation", query: "{ mutation query }", wantType: "query"},
+		{
+			name:          "operationName selects",
+			query:         "query A { a } mutation B { b }",
+			operationName: "B",
+			wantType:      "mutation",
+			wantName:      "B",
+		},
+		{name: "several operations without operationName", query: "query A { a } query B { b }", wantErr: true},
+		{name: "unknown operationName", query: "query A { a }", operationName: "B", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexGraphQL(tt.query)
+			require.NoError(t, err)
+
+			gotType, gotName, err := selectGraphQLOperation(tokens, tt.operationName)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantType, gotType)
+			assert.Equal(t, tt.wantName, gotName)
+		})
+	}
+}
+
+func sha256ForTest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseGraphQLRequest(t *testing.T) {
+	query := "query Me { me { id } }"
+	hash := sha256ForTest(query)
+
+	t.Run("json", func(t *testing.T) {
+		got, err := parseGraphQLRequest("application/json", []byte(`{"query": "query Me {\n me { id }\n}", "variables": {"b": 2, "a": 1}}`))
+		require.NoError(t, err)
+		assert.Equal(t, "query", got.Type)
+		assert.Equal(t, "Me", got.Name)
+		assert.Equal(t, "query Me{me{id}}", got.Query)
+		assert.NotNil(t, got.Variables)
+	})
+
+	t.Run("application/graphql", func(t *testing.T) {
+		got, err := parseGraphQLRequest("application/graphql", []byte(query))
+		require.NoError(t, err)
+		assert.Equal(t, "query Me{me{id}}", got.Query)
+	})
+
+	t.Run("persisted query hash only", func(t *testing.T) {
+		got, err := parseGraphQLRequest("application/json", []byte(`{"operationName": "Me", "extensions": {"persistedQuery": {"version": 1, "sha256Hash": "`+hash+`"}}}`))
+		require.NoError(t, err)
+		assert.Equal(t, "", got.Type)
+		assert.Equal(t, "Me", got.Name)
+		assert.Equal(t, hash, got.PersistedQueryHash)
+	})
+
+	t.Run("persisted query with query", func(t *testing.T) {
+		got, err := parseGraphQLRequest("application/json", []byte(`{"query": "`+query+`", "extensions": {"persistedQuery": {"version": 1, "sha256Hash": "`+hash+`"}}}`))
+		require.NoError(t, err)
+		assert.Equal(t, "query", got.Type)
+		assert.Equal(t, hash, got.PersistedQueryHash)
+	})
+
+	errorCases := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "hash mismatch", contentType: "application/json", body: `{"query": "{ a }", "extensions": {"persistedQuery": {"sha256Hash": "` + hash + `"}}}`},
+		{name: "batch", contentType: "application/json", body: `[{"query": "{ a }"}]`},
+		{name: "no query", contentType: "application/json", body: `{"variables": {}}`},
+		{name: "invalid document", contentType: "application/json", body: `{"query": "{ a % }"}`},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: `query=%7Ba%7D`},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseGraphQLRequest(tt.contentType, []byte(tt.body))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGraphQLOperation_cacheKeyBody(t *testing.T) {
+	keyFor := func(body string) string {
+		operation, err := parseGraphQLRequest("application/json", []byte(body))
+		require.NoError(t, err)
+		key, err := operation.cacheKeyBody()
+		require.NoError(t, err)
+		return string(key)
+	}
+
+	query := "query Me($id: ID) { me(id: $id) { id } }"
+	hash := sha256ForTest(query)
+
+	base := keyFor(`{"query": "query Me($id: ID) {\n  me(id: $id) { id }\n}", "variables": {"id": 1, "x": "y"}}`)
+	assert.Equal(t, base, keyFor(`{"query": "query Me($id:ID){me(id:$id){id}}", "variables": {"x": "y", "id": 1}}`))
+	assert.NotEqual(t, base, keyFor(`{"query": "query Me($id:ID){me(id:$id){id}}", "variables": {"id": 2, "x": "y"}}`))
+
+	// 해시만 보낸 요청과 문서까지 보낸 요청은 같은 키다
+	assert.Equal(t,
+		keyFor(`{"query": "`+query+`", "variables": {"id": 1}, "extensions": {"persistedQuery": {"sha256Hash": "`+hash+`"}}}`),
+		keyFor(`{"operationName": "Me", "variables": {"id": 1}, "extensions": {"persistedQuery": {"sha256Hash": "`+hash+`"}}}`),
+	)
+}
+
+func TestConfig_graphQLOperation(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+
+	operationFor := func(body string) (*graphQLOperation, error) {
+		kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+			{Method: "kong.request.get_header", Args: bridge.WrapString("Content-Type"), Ret: bridge.WrapString("application/json")},
+		})
+		defer recorder.assertConsumed()
+		return cfg.graphQLOperation(kong, "POST", []byte(body))
+	}
+
+	operation, err := operationFor(`{"query": "query Me { me { id } }"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "Me", operation.Name)
+
+	_, err = operationFor(`{"query": "mutation Like { like }"}`)
+	assert.Error(t, err)
+
+	_, err = operationFor(`{"query": "subscription OnLike { onLike }"}`)
+	assert.Error(t, err)
+
+	// 본문이 없는 POST 요청은 GraphQL 요청으로 보지 않는다
+	kong, recorder := mockPdkSteps(t, nil)
+	operation, err = cfg.graphQLOperation(kong, "POST", nil)
+	recorder.assertConsumed()
+	assert.NoError(t, err)
+	assert.Nil(t, operation)
+
+	getOperationFor := func(args map[string][]string) (*graphQLOperation, error) {
+		query, err := bridge.WrapHeaders(args)
+		require.NoError(t, err)
+		kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+			{Method: "kong.request.get_query", Args: &kong_plugin_protocol.Int{V: 1000}, Ret: query},
+		})
+		defer recorder.assertConsumed()
+		return cfg.graphQLOperation(kong, "GET", nil)
+	}
+
+	// GET 요청은 쿼리 인자에서 해석한다
+	operation, err = getOperationFor(map[string][]string{
+		"query":         {"query Me($id: ID) { me(id: $id) { id } } query Other { other }"},
+		"operationName": {"Me"},
+		"variables":     {`{"id": 1}`},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "query", operation.Type)
+	assert.Equal(t, "Me", operation.Name)
+	assert.Equal(t, map[string]any{"id": json.Number("1")}, operation.Variables)
+
+	hash := sha256ForTest("query Me { me { id } }")
+	operation, err = getOperationFor(map[string][]string{
+		"extensions": {`{"persistedQuery": {"version": 1, "sha256Hash": "` + hash + `"}}`},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, hash, operation.PersistedQueryHash)
+
+	// GET 으로 보낸 mutation 도 캐시하지 않는다
+	_, err = getOperationFor(map[string][]string{"query": {"mutation Like { like }"}})
+	assert.Error(t, err)
+
+	_, err = getOperationFor(map[string][]string{"query": {"{ me { id } }"}, "variables": {"{"}})
+	assert.Error(t, err)
+
+	// GraphQL 인자가 없는 GET 요청은 GraphQL 요청으로 보지 않는다
+	operation, err = getOperationFor(map[string][]string{"page": {"1"}})
+	assert.NoError(t, err)
+	assert.Nil(t, operation)
+}
+
+func TestConfig_cacheableGraphQLOperation(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	// APQ 해시에서 찾은 작업 종류는 캐시에 남으므로 다른 실행과 캐시를 나누지 않는다
+	isolateInMemoryForTest(t, cfg)
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+
+	assert.True(t, cfg.cacheableGraphQLOperation(marshal, &graphQLOperation{Type: "query"}))
+
+	queryHash := sha256ForTest("query Me { me { id } }")
+	mutationHash := sha256ForTest("mutation Like { like }")
+
+	// 처음 보는 해시는 캐시하지 않는다
+	assert.False(t, cfg.cacheableGraphQLOperation(marshal, &graphQLOperation{PersistedQueryHash: queryHash}))
+
+	assert.True(t, cfg.cacheableGraphQLOperation(marshal, &graphQLOperation{Type: "query", PersistedQueryHash: queryHash}))
+	assert.False(t, cfg.cacheableGraphQLOperation(marshal, &graphQLOperation{Type: "mutation", PersistedQueryHash: mutationHash}))
+
+	require.Eventually(t, func() bool {
+		return cfg.cacheableGraphQLOperation(marshal, &graphQLOperation{PersistedQueryHash: queryHash})
+	}, time.Second, 10*time.Millisecond)
+
+	mutation := &graphQLOperation{PersistedQueryHash: mutationHash}
+	require.Eventually(t, func() bool {
+		cfg.cacheableGraphQLOperation(marshal, mutation)
+		return mutation.Type != ""
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "mutation", mutation.Type)
+	assert.False(t, cfg.cacheableGraphQLOperation(marshal, mutation))
+}
+
+func TestConfig_cacheableOperationName(t *testing.T) {
+	cfg := configDefault()
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+	cfg.Filters = []Filter{
+		{Name: "products", Rules: []Rule{{OperationName: true, Regexp: "^(GetProduct|ListProducts)$"}}, CacheTTL: 30},
+	}
+
+	ok, filter := cfg.filtered(nil, "GetProduct")
+	assert.True(t, ok)
+	assert.Equal(t, 30, filter.CacheTTL)
+
+	ok, _ = cfg.filtered(nil, "GetCart")
+	assert.False(t, ok)
+
+	ok, _ = cfg.filtered(nil, "")
+	assert.False(t, ok)
+}
+
+func Test_Access_InMemory_GraphQLMutation(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.CacheTTL = 60
+	cfg.GraphQL = true
+	cfg.RequestMethods = []string{"POST"}
+
+	kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+		{Method: "kong.request.get_method", Ret: bridge.WrapString("POST")},
+		{Method: "kong.request.get_path", Ret: bridge.WrapString("/graphql")},
+		{Method: "kong.request.get_raw_body", Ret: &kong_plugin_protocol.RawBodyResult{Kind: &kong_plugin_protocol.RawBodyResult_Content{Content: []byte(`{"query": "mutation Like { like }"}`)}}},
+		{Method: "kong.request.get_header", Args: bridge.WrapString("Content-Type"), Ret: bridge.WrapString("application/json")},
+		{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Bypass")}},
+	})
+	cfg.Access(kong)
+	recorder.assertConsumed()
+}
+
+func Test_Access_InMemory_GraphQLGetMutation(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.CacheTTL = 60
+	cfg.GraphQL = true
+
+	query, err := bridge.WrapHeaders(map[string][]string{"query": {"mutation Like { like }"}})
+	require.NoError(t, err)
+	kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+		{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+		{Method: "kong.request.get_path", Ret: bridge.WrapString("/graphql")},
+		{Method: "kong.request.get_raw_body", Ret: &kong_plugin_protocol.RawBodyResult{Kind: &kong_plugin_protocol.RawBodyResult_Content{}}},
+		{Method: "kong.request.get_query", Args: &kong_plugin_protocol.Int{V: 1000}, Ret: query},
+		{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Bypass")}},
+	})
+	cfg.Access(kong)
+	recorder.assertConsumed()
+}
+
+func TestWithoutGraphQLQueryArgs(t *testing.T) {
+	args := map[string][]string{"query": {"{ me { id } }"}, "operationName": {"Me"}, "variables": {"{}"}, "extensions": {"{}"}, "locale": {"ko"}}
+	assert.Equal(t, map[string][]string{"locale": {"ko"}}, withoutGraphQLQueryArgs(args))
+	// 원래 인자는 고치지 않는다
+	assert.Len(t, args, 5)
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kong/go-pdk"
+	"github.com/dgraph-io/ristretto"
+	"github.com/eko/gocache/lib/v4/marshaler"
+	lib_store "github.com/eko/gocache/lib/v4/store"
+	"github.com/mitchellh/hashstructure/v2"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyPrefix 는 Idempotency-Key 별 첫 응답을 저장하는 스토어 키의 접두어다.
+	idempotencyPrefix = "sonic-boom:idempotency:"
+)
+
+// idempotencyRecord 는 Idempotency-Key 로 처음 처리한 요청의 지문과 응답이다.
+type idempotencyRecord struct {
+	// Fingerprint 는 메소드, 경로, 본문의 해시다. 같은 키로 다른 요청을 보내면 422 로 응답한다
+	Fingerprint string
+	Value       *CacheValue
+}
+
+// idempotencyScope 는 같은 Idempotency-Key 라도 컨슈머마다 따로 저장하도록 키를 만든다.
+type idempotencyScope struct {
+	Consumer string
+	Key      string
+}
+
+// idempotencyKey 는 idempotency 모드에서 안전하지 않은 메소드의 Idempotency-Key 헤더를 돌려준다.
+// GET, HEAD 처럼 안전한 메소드는 헤더를 보지 않는다.
+func (conf *Config) idempotencyKey(kong *pdk.PDK, method string) string {
+	if !conf.Idempotency {
+		return ""
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return ""
+	}
+
+	key, err := kong.Request.GetHeader(idempotencyKeyHeader)
+	if err != nil {
+		conf.logger.Debug().Err(err).Msgf("Failed to get request header `%s`", idempotencyKeyHeader)
+		return ""
+	}
+	return key
+}
+
+func (conf *Config) idempotencyTTL() time.Duration {
+	return time.Duration(conf.IdempotencyTTL) * time.Second
+}
+
+func (conf *Config) idempotencyLockTTL() time.Duration {
+	return time.Duration(conf.IdempotencyLockMs) * time.Millisecond
+}
+
+// requestFingerprint 는 Idempotency-Key 를 다른 요청에 다시 썼는지 가려내는 지문이다.
+func requestFingerprint(method string, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + "\n" + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func loadIdempotencyRecord(ctx context.Context, marshal *marshaler.Marshaler, keyID string) *idempotencyRecord {
+	cached, err := marshal.Get(ctx, keyID, new(idempotencyRecord))
+	if cached == nil || err != nil {
+		return nil
+	}
+	return cached.(*idempotencyRecord)
+}
+
+// idempotent 는 Idempotency-Key 가 있는 요청을 처리한다.
+//
+//   - 같은 키로 저장된 응답이 있으면 그대로 돌려준다. 요청이 다르면 422 로 응답한다
+//   - 같은 키의 요청이 아직 처리 중이면 409 로 응답한다
+//   - 처음 보는 키이면 잠금을 얻고 업스트림으로 보낸다. Response 에서 응답을 저장하고 잠금을 푼다
+func (conf *Config) idempotent(kong *pdk.PDK, key string, method string, path string) {
+	logger := conf.logger
+
+	rawBody, err := conf.requestRawBody(kong)
+	if err != nil {
+		return
+	}
+
+	consumerID, err := consumerID(kong)
+	if err != nil {
+		logger.Error().Err(err).Msg("Getting consumerID has failed")
+	}
+	hash, err := hashstructure.Hash(idempotencyScope{Consumer: consumerID, Key: key}, hashstructure.FormatV2, nil)
+	if err != nil {
+		logger.Error().Err(err).Msg("hashing error")
+		return
+	}
+	keyID := idempotencyPrefix + strconv.FormatUint(hash, 10)
+	fingerprint := requestFingerprint(method, path, rawBody)
+
+	_, marshal, err := conf.newCacheManager(conf.IdempotencyTTL)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create cache manager")
+		return
+	}
+
+	if conf.replayIdempotent(kong, marshal, keyID, fingerprint) {
+		return
+	}
+
+	lock, err := conf.newFillLock()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create fill lock")
+		return
+	}
+	token, err := lock.acquire(context.Background(), keyID, conf.idempotencyLockTTL())
+	if err != nil {
+		logger.Error().Err(err).Msgf("Failed to acquire idempotency lock for '%s'", keyID)
+		return
+	}
+	if token == "" {
+		conf.exitIdempotencyError(kong, http.StatusConflict, "A request with the same Idempotency-Key is being processed")
+		return
+	}
+
+	// 잠금을 얻기 직전에 첫 요청이 끝났을 수 있다
+	if conf.replayIdempotent(kong, marshal, keyID, fingerprint) {
+		if err := lock.release(context.Background(), keyID, token, nil); err != nil {
+			logger.Error().Err(err).Msgf("Failed to release idempotency lock for '%s'", keyID)
+		}
+		return
+	}
+
+	signal := CacheSignal{
+		CacheKeyID:  keyID,
+		LockToken:   token,
+		Idempotency: true,
+		Fingerprint: fingerprint,
+		Path:        conf.cacheKeyPath(path),
+		Invalidate:  conf.invalidatesOn(method),
+	}
+	if err := conf.signalCacheReq(kong, signal); err != nil {
+		logger.Error().Err(err).Msg("Failed to signal cache request")
+		if err := lock.release(context.Background(), keyID, token, nil); err != nil {
+			logger.Error().Err(err).Msgf("Failed to release idempotency lock for '%s'", keyID)
+		}
+	}
+}
+
+// replayIdempotent 는 저장된 응답이 있으면 응답하고 true 를 돌려준다.
+func (conf *Config) replayIdempotent(kong *pdk.PDK, marshal *marshaler.Marshaler, keyID string, fingerprint string) bool {
+	logger := conf.logger
+
+	record := loadIdempotencyRecord(context.Background(), marshal, keyID)
+	if record == nil || record.Value == nil {
+		return false
+	}
+
+	if record.Fingerprint != fingerprint {
+		conf.exitIdempotencyError(kong, http.StatusUnprocessableEntity, "The Idempotency-Key is already used for a different request")
+		return true
+	}
+
+	value := record.Value
+	headers := value.responseHeaders("Hit", time.Now().Unix())
+	headers["Idempotent-Replayed"] = []string{"true"}
+	logger.Debug().Msgf("Idempotent response '%s' is replayed", keyID)
+	kong.Response.Exit(value.Status, value.Body, headers)
+	return true
+}
+
+func (conf *Config) exitIdempotencyError(kong *pdk.PDK, status int, message string) {
+	conf.logger.Debug().Msg(message)
+	kong.Response.Exit(status, []byte(`{"message":"`+message+`"}`), map[string][]string{
+		"Content-Type":   {"application/json; charset=utf-8"},
+		"X-Cache-Status": {"Bypass"},
+	})
+}
+
+// storeIdempotent 는 Idempotency-Key 로 처음 처리한 요청의 응답을 저장한다.
+// 5xx 응답은 다시 시도할 수 있도록 저장하지 않는다.
+func (conf *Config) storeIdempotent(kong *pdk.PDK, httpStatus int, signal CacheSignal) *CacheValue {
+	logger := conf.logger
+
+	if httpStatus >= http.StatusInternalServerError {
+		logger.Debug().Msgf("Idempotent response '%s' is not stored: %d", signal.CacheKeyID, httpStatus)
+		return nil
+	}
+
+	headers, err := kong.Response.GetHeaders(1000)
+	if err != nil {
+		logger.Error().Err(err).Msg("Getting response headers failed")
+		return nil
+	}
+	rawBody, err := serviceResponseRawBody(kong)
+	if err != nil {
+		logger.Error().Err(err).Msg("Getting response body has failed")
+		return nil
+	}
+
+	value := &CacheValue{
+		Status:    httpStatus,
+		Headers:   headers,
+		Body:      rawBody,
+		BodyLen:   len(rawBody),
+		Timestamp: time.Now().Unix(),
+		TTL:       int64(conf.IdempotencyTTL),
+		Version:   conf.CacheVersion,
+	}
+
+	_, marshal, err := conf.newCacheManager(conf.IdempotencyTTL)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create cache manager")
+		return nil
+	}
+	record := &idempotencyRecord{Fingerprint: signal.Fingerprint, Value: value}
+	if err := marshal.Set(context.Background(), signal.CacheKeyID, record, lib_store.WithExpiration(conf.idempotencyTTL())); err != nil {
+		logger.Error().Err(err).Msg("Idempotent response set failed")
+		return nil
+	}
+
+	// ristretto 는 비동기로 저장하므로, 잠금을 푼 뒤 바로 온 재시도가 기록을 보도록 기다린다
+	if conf.Strategy == "in-memory" {
+		if client, ok := ristrettoClients.Load(conf.InMemory); ok {
+			client.(*ristretto.Cache).Wait()
+		}
+	}
+	logger.Debug().Msgf("Idempotent response set: %s", signal.CacheKeyID)
+	return value
+}
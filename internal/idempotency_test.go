@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/Kong/go-pdk/server/kong_plugin_protocol"
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	fingerprint := requestFingerprint("POST", "/orders", []byte(`{"amount":1}`))
+	assert.Equal(t, fingerprint, requestFingerprint("POST", "/orders", []byte(`{"amount":1}`)))
+	assert.NotEqual(t, fingerprint, requestFingerprint("POST", "/orders", []byte(`{"amount":2}`)))
+	assert.NotEqual(t, fingerprint, requestFingerprint("PUT", "/orders", []byte(`{"amount":1}`)))
+	assert.NotEqual(t, fingerprint, requestFingerprint("POST", "/refunds", []byte(`{"amount":1}`)))
+}
+
+func TestConfig_idempotencyKey(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+
+	// 꺼져 있거나 안전한 메소드면 헤더를 보지 않는다
+	kong, recorder := mockPdkSteps(t, nil)
+	assert.Empty(t, cfg.idempotencyKey(kong, http.MethodPost))
+	cfg.Idempotency = true
+	assert.Empty(t, cfg.idempotencyKey(kong, http.MethodGet))
+	recorder.assertConsumed()
+
+	kong, recorder = mockPdkSteps(t, []bridgetest.MockStep{
+		{Method: "kong.request.get_header", Args: bridge.WrapString("Idempotency-Key"), Ret: bridge.WrapString("k-1")},
+	})
+	assert.Equal(t, "k-1", cfg.idempotencyKey(kong, http.MethodPost))
+	recorder.assertConsumed()
+}
+
+func Test_Access_InMemory_Idempotency(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.Idempotency = true
+	cfg.IdempotencyTTL = 60
+	cfg.IdempotencyLockMs = 60000
+
+	key := "order-" + newRandomToken()
+	body := `{"amount":100}`
+	hash, err := hashstructure.Hash(idempotencyScope{Consumer: "001", Key: key}, hashstructure.FormatV2, nil)
+	require.NoError(t, err)
+	keyID := idempotencyPrefix + strconv.FormatUint(hash, 10)
+
+	accessSteps := func(body string, rest ...bridgetest.MockStep) []bridgetest.MockStep {
+		return append([]bridgetest.MockStep{
+			{Method: "kong.request.get_method", Ret: bridge.WrapString("POST")},
+			{Method: "kong.request.get_path", Ret: bridge.WrapString("/orders")},
+			{Method: "kong.request.get_header", Args: bridge.WrapString("Idempotency-Key"), Ret: bridge.WrapString(key)},
+			{Method: "kong.request.get_raw_body", Ret: &kong_plugin_protocol.RawBodyResult{Kind: &kong_plugin_protocol.RawBodyResult_Content{Content: []byte(body)}}},
+			{Method: "kong.client.get_consumer", Ret: &kong_plugin_protocol.Consumer{Id: "001"}},
+		}, rest...)
+	}
+
+	// 첫 요청은 잠금을 얻고 업스트림으로 간다
+	kong, recorder := mockPdkSteps(t, accessSteps(body,
+		bridgetest.MockStep{Method: "kong.ctx.shared.set"},
+		bridgetest.MockStep{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Miss")}},
+	))
+	cfg.Access(kong)
+	recorder.assertConsumed()
+
+	held, ok := memoryLocks.Load(keyID)
+	require.True(t, ok)
+	token := held.(*memoryLock).token
+
+	// 처리 중인 요청과 같은 키로 온 재시도는 409 로 응답한다
+	kong, recorder = mockPdkSteps(t, accessSteps(body, bridgetest.MockStep{Method: "kong.response.exit"}))
+	cfg.Access(kong)
+	recorder.assertConsumed()
+	assert.Equal(t, int32(http.StatusConflict), recorder.exitArgs().Status)
+
+	// 첫 요청의 응답을 저장하고 잠금을 푼다
+	responseHeaders, err := bridge.WrapHeaders(map[string][]string{"Content-Type": {"application/json"}})
+	require.NoError(t, err)
+	signal := CacheSignal{
+		CacheKeyID:  keyID,
+		LockToken:   token,
+		Idempotency: true,
+		Fingerprint: requestFingerprint("POST", "/orders", []byte(body)),
+	}
+	kong, recorder = mockPdkSteps(t, append(responseSignalSteps(t, http.StatusCreated, signal),
+		bridgetest.MockStep{Method: "kong.response.get_headers", Ret: responseHeaders},
+		bridgetest.MockStep{Method: "kong.service.response.get_raw_body", Ret: &kong_plugin_protocol.RawBodyResult{Kind: &kong_plugin_protocol.RawBodyResult_Content{Content: []byte(`{"id":1}`)}}},
+	))
+	cfg.Response(kong)
+	recorder.assertConsumed()
+	_, ok = memoryLocks.Load(keyID)
+	assert.False(t, ok)
+
+	// 재시도는 저장된 응답을 받는다
+	kong, recorder = mockPdkSteps(t, accessSteps(body, bridgetest.MockStep{Method: "kong.response.exit"}))
+	cfg.Access(kong)
+	recorder.assertConsumed()
+	exit := recorder.exitArgs()
+	assert.Equal(t, int32(http.StatusCreated), exit.Status)
+	assert.Equal(t, []byte(`{"id":1}`), exit.Body)
+	replayed := bridge.UnwrapHeaders(exit.Headers)
+	assert.Equal(t, []string{"true"}, replayed["Idempotent-Replayed"])
+
+	// 같은 키로 다른 요청을 보내면 422 로 응답한다
+	kong, recorder = mockPdkSteps(t, accessSteps(`{"amount":200}`, bridgetest.MockStep{Method: "kong.response.exit"}))
+	cfg.Access(kong)
+	recorder.assertConsumed()
+	assert.Equal(t, int32(http.StatusUnprocessableEntity), recorder.exitArgs().Status)
+}
+
+func Test_Response_InMemory_IdempotencyServerError(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.Idempotency = true
+	cfg.IdempotencyTTL = 60
+	cfg.IdempotencyLockMs = 60000
+
+	keyID := idempotencyPrefix + "server-error"
+	token, err := memoryFillLock{}.acquire(context.Background(), keyID, cfg.idempotencyLockTTL())
+	require.NoError(t, err)
+
+	// 5xx 응답은 저장하지 않고 잠금만 푼다
+	signal := CacheSignal{CacheKeyID: keyID, LockToken: token, Idempotency: true}
+	kong, recorder := mockPdkSteps(t, responseSignalSteps(t, http.StatusBadGateway, signal))
+	cfg.Response(kong)
+	recorder.assertConsumed()
+
+	_, ok := memoryLocks.Load(keyID)
+	assert.False(t, ok)
+}
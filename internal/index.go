@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eko/gocache/lib/v4/marshaler"
+	lib_store "github.com/eko/gocache/lib/v4/store"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// pathIndexPrefix 는 경로별로 캐시 키를 모아 두는 인덱스의 접두어다.
+	pathIndexPrefix = "sonic-boom:path:"
+	// serviceIndexPrefix, routeIndexPrefix 는 Kong 서비스, 라우트별 인덱스의 접두어다.
+	serviceIndexPrefix = "sonic-boom:service:"
+	routeIndexPrefix   = "sonic-boom:route:"
+
+	// unboundedIndexTTL 은 ttl 이 0 인, 만료되지 않는 캐시 키를 인덱스에 두는 기간이다.
+	// 인덱스가 끝없이 커지지 않도록 두며, 그동안 다시 저장하지 않은 캐시 키는 purge 로 찾을 수 없다.
+	unboundedIndexTTL = 24 * time.Hour
+)
+
+func pathIndexName(path string) string {
+	return pathIndexPrefix + path
+}
+
+func serviceIndexName(id string) string {
+	return serviceIndexPrefix + id
+}
+
+func routeIndexName(id string) string {
+	return routeIndexPrefix + id
+}
+
+// keyIndex 는 이름(경로 등)별로 캐시 키를 모아 두는 인덱스다. 캐시 키가 해시라서 경로 등으로는 찾을 수 없으므로,
+// 저장할 때 인덱스에 기록해 두고 지울 때 찾는다.
+// in-memory 전략은 프로세스 안의 맵에, redis 전략은 Redis set 에 기록한다.
+type keyIndex interface {
+	// add 는 cacheKeyID 를 인덱스에 더한다. 인덱스는 적어도 ttl 동안 남는다. ttl 이 0 이하이면 unboundedIndexTTL 동안 남는다
+	add(ctx context.Context, name string, cacheKeyID string, ttl time.Duration) error
+	// members 는 인덱스의 캐시 키들을 돌려준다. 이미 만료된 캐시 키가 섞여 있을 수 있다
+	members(ctx context.Context, name string) ([]string, error)
+	// delete 는 인덱스를 지운다
+	delete(ctx context.Context, name string) error
+	// names 는 prefix 로 시작하는 인덱스 이름들을 돌려준다
+	names(ctx context.Context, prefix string) ([]string, error)
+}
+
+// memoryKeyIndex 는 in-memory 전략의 인덱스다. 만료된 캐시 키와 ristretto 가 밀어낸 캐시 키는 가끔 한꺼번에 치운다.
+type memoryKeyIndex struct {
+	mu   sync.Mutex
+	sets map[string]map[string]time.Time
+	// stored 는 캐시 키가 아직 스토어에 있는지 알려준다. nil 이면 만료 시각만 본다
+	stored    func(cacheKeyID string) bool
+	lastPrune time.Time
+}
+
+// 만료된 캐시 키를 치우는 주기
+const memoryIndexPruneInterval = time.Minute
+
+// InMemory 설정값별 인덱스. ristrettoClients 와 같이 설정값별로 나눈다
+var memoryIndexes sync.Map // map[InMemoryConfig]*memoryKeyIndex
+
+func (idx *memoryKeyIndex) add(_ context.Context, name string, cacheKeyID string, ttl time.Duration) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(idx.lastPrune) > memoryIndexPruneInterval {
+		idx.prune(now)
+	}
+
+	set, ok := idx.sets[name]
+	if !ok {
+		set = map[string]time.Time{}
+		idx.sets[name] = set
+	}
+	if ttl <= 0 {
+		ttl = unboundedIndexTTL
+	}
+	// 만료 시각은 늘리기만 한다. 먼저 저장한 값이 더 오래 남을 수 있다
+	if expiresAt := now.Add(ttl); expiresAt.After(set[cacheKeyID]) {
+		set[cacheKeyID] = expiresAt
+	}
+	return nil
+}
+
+func indexEntryAlive(expiresAt time.Time, now time.Time) bool {
+	return now.Before(expiresAt)
+}
+
+func (idx *memoryKeyIndex) members(_ context.Context, name string) ([]string, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for key, expiresAt := range idx.sets[name] {
+		if indexEntryAlive(expiresAt, now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (idx *memoryKeyIndex) delete(_ context.Context, name string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.sets, name)
+	return nil
+}
+
+func (idx *memoryKeyIndex) names(_ context.Context, prefix string) ([]string, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	now := time.Now()
+	var names []string
+	for name, set := range idx.sets {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		for _, expiresAt := range set {
+			if indexEntryAlive(expiresAt, now) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// prune 은 만료되었거나 스토어에 없는 캐시 키와 빈 인덱스를 치운다. mu 를 잡고 부른다.
+func (idx *memoryKeyIndex) prune(now time.Time) {
+	for name, set := range idx.sets {
+		for key, expiresAt := range set {
+			if !indexEntryAlive(expiresAt, now) || idx.stored != nil && !idx.stored(key) {
+				delete(set, key)
+			}
+		}
+		if len(set) == 0 {
+			delete(idx.sets, name)
+		}
+	}
+	idx.lastPrune = now
+}
+
+// 인덱스의 만료 시간은 늘리기만 한다. 줄이면 먼저 저장한 캐시 키를 찾지 못한다.
+var redisIndexAddScript = redis.NewScript(`
+local existed = redis.call("exists", KEYS[1])
+redis.call("sadd", KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+local current = redis.call("ttl", KEYS[1])
+if existed == 0 or (current >= 0 and current < ttl) then
+	redis.call("expire", KEYS[1], ttl)
+end
+return 1
+`)
+
+// redisKeyIndex 는 redis, redis-cluster 전략의 인덱스다.
+type redisKeyIndex struct {
+	client redis.UniversalClient
+}
+
+func (idx *redisKeyIndex) add(ctx context.Context, name string, cacheKeyID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = unboundedIndexTTL
+	}
+	// 1초보다 짧아도 바로 사라지지 않도록 올린다
+	seconds := max(int64(ttl/time.Second), 1)
+	return redisIndexAddScript.Run(ctx, idx.client, []string{name}, cacheKeyID, seconds).Err()
+}
+
+func (idx *redisKeyIndex) members(ctx context.Context, name string) ([]string, error) {
+	return idx.client.SMembers(ctx, name).Result()
+}
+
+func (idx *redisKeyIndex) delete(ctx context.Context, name string) error {
+	return idx.client.Del(ctx, name).Err()
+}
+
+// names 는 SCAN 으로 인덱스를 찾는다. redis-cluster 는 모든 마스터 노드를 훑는다.
+func (idx *redisKeyIndex) names(ctx context.Context, prefix string) ([]string, error) {
+	pattern := redisGlobEscaper.Replace(prefix) + "*"
+
+	if cluster, ok := idx.client.(*redis.ClusterClient); ok {
+		var mu sync.Mutex
+		var names []string
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			found, err := scanKeys(ctx, client, pattern)
+			mu.Lock()
+			names = append(names, found...)
+			mu.Unlock()
+			return err
+		})
+		return names, err
+	}
+	return scanKeys(ctx, idx.client, pattern)
+}
+
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func scanKeys(ctx context.Context, client redis.Cmdable, pattern string) ([]string, error) {
+	var keys []string
+	iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (conf *Config) newKeyIndex() (keyIndex, error) {
+	if conf.Strategy == "in-memory" {
+		if idx, ok := memoryIndexes.Load(conf.InMemory); ok {
+			return idx.(*memoryKeyIndex), nil
+		}
+		client, err := conf.ristrettoClient()
+		if err != nil {
+			return nil, err
+		}
+		actual, _ := memoryIndexes.LoadOrStore(conf.InMemory, &memoryKeyIndex{
+			sets: map[string]map[string]time.Time{},
+			// GetTTL 은 ristretto 의 접근 빈도를 올리지 않는다
+			stored: func(cacheKeyID string) bool {
+				_, ok := client.GetTTL(cacheKeyID)
+				return ok
+			},
+		})
+		return actual.(*memoryKeyIndex), nil
+	}
+
+	client, err := conf.newRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	return &redisKeyIndex{client: client}, nil
+}
+
+// purgeIndex 는 인덱스에 모인 캐시 키를 모두 지우고 인덱스도 지운다. 지운 캐시 키의 수를 돌려준다.
+// 이미 만료되었거나 밀려나 스토어에 없던 캐시 키는 세지 않는다.
+func purgeIndex(ctx context.Context, marshal *marshaler.Marshaler, index keyIndex, name string) (int, error) {
+	keys, err := index.members(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, key := range keys {
+		exists := getCacheValue(ctx, marshal, key) != nil
+		if err := marshal.Delete(ctx, key); err != nil {
+			return purged, err
+		}
+		if exists {
+			purged++
+		}
+	}
+	return purged, index.delete(ctx, name)
+}
+
+// softPurgeIndex 는 인덱스에 모인 캐시 키를 soft purge 한다. 나중에 지울 수 있도록 인덱스는 남긴다.
+// soft purge 한 캐시 키의 수를 돌려준다.
+func softPurgeIndex(ctx context.Context, marshal *marshaler.Marshaler, index keyIndex, name string, now int64) (int, error) {
+	keys, err := index.members(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, key := range keys {
+		ok, err := softPurgeKey(ctx, marshal, key, now)
+		if err != nil {
+			return purged, err
+		}
+		if ok {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// softPurgeKey 는 저장된 값을 지우지 않고 soft purge 한 시각을 적어 stale 하게 만든다.
+// 본문은 남아 있으므로 stale-while-revalidate, stale-if-error 기간 동안 응답할 수 있다. 보관 기간은 바꾸지 않는다.
+func softPurgeKey(ctx context.Context, marshal *marshaler.Marshaler, cacheKeyID string, now int64) (bool, error) {
+	value := getCacheValue(ctx, marshal, cacheKeyID)
+	if value == nil {
+		return false, nil
+	}
+	if value.Purged == 0 {
+		value.Purged = now
+	}
+
+	var expiration time.Duration
+	if storageTTL := value.storageTTL(); storageTTL > 0 {
+		remaining := value.Timestamp + int64(storageTTL) - now
+		if remaining <= 0 {
+			return false, nil
+		}
+		expiration = time.Duration(remaining) * time.Second
+	}
+	if err := marshal.Set(ctx, cacheKeyID, value, lib_store.WithExpiration(expiration)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
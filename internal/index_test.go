@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	lib_store "github.com/eko/gocache/lib/v4/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addExpiredForTest 는 이미 만료된 캐시 키를 인덱스에 넣는다.
+func addExpiredForTest(idx *memoryKeyIndex, name string, cacheKeyID string) {
+	if idx.sets[name] == nil {
+		idx.sets[name] = map[string]time.Time{}
+	}
+	idx.sets[name][cacheKeyID] = time.Now().Add(-time.Second)
+}
+
+func TestMemoryKeyIndex(t *testing.T) {
+	ctx := context.Background()
+	idx := &memoryKeyIndex{sets: map[string]map[string]time.Time{}}
+
+	require.NoError(t, idx.add(ctx, "path:/a", "k1", time.Minute))
+	require.NoError(t, idx.add(ctx, "path:/a", "k2", time.Minute))
+	addExpiredForTest(idx, "path:/a", "expired")
+	// 만료되지 않는 캐시 키
+	require.NoError(t, idx.add(ctx, "path:/a", "forever", 0))
+	require.NoError(t, idx.add(ctx, "path:/b", "k3", time.Minute))
+
+	addExpiredForTest(idx, "path:/ab", "expired")
+
+	keys, err := idx.members(ctx, "path:/a")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"k1", "k2", "forever"}, keys)
+
+	// 만료된 캐시 키만 남은 인덱스는 돌려주지 않는다
+	names, err := idx.names(ctx, "path:/a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"path:/a"}, names)
+
+	require.NoError(t, idx.delete(ctx, "path:/a"))
+	keys, err = idx.members(ctx, "path:/a")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// 만료된 캐시 키와 빈 인덱스는 치운다
+	addExpiredForTest(idx, "path:/c", "expired")
+	require.NoError(t, idx.add(ctx, "path:/d", "forever", 0))
+	idx.prune(time.Now().Add(time.Hour))
+	assert.NotContains(t, idx.sets, "path:/c")
+	assert.NotContains(t, idx.sets, "path:/b")
+	assert.Contains(t, idx.sets, "path:/d")
+
+	// ttl 이 0 인 캐시 키도 unboundedIndexTTL 이 지나면 치운다
+	idx.prune(time.Now().Add(unboundedIndexTTL + time.Minute))
+	assert.NotContains(t, idx.sets, "path:/d")
+}
+
+func TestMemoryKeyIndex_PruneEvicted(t *testing.T) {
+	ctx := context.Background()
+	stored := map[string]bool{"k1": true}
+	idx := &memoryKeyIndex{
+		sets:   map[string]map[string]time.Time{},
+		stored: func(cacheKeyID string) bool { return stored[cacheKeyID] },
+	}
+
+	require.NoError(t, idx.add(ctx, "path:/a", "k1", 0))
+	require.NoError(t, idx.add(ctx, "path:/a", "k2", 0))
+	require.NoError(t, idx.add(ctx, "path:/b", "k3", time.Minute))
+
+	// 스토어에서 밀려난 캐시 키는 만료 전이라도 치운다
+	idx.prune(time.Now())
+	assert.Equal(t, map[string]map[string]time.Time{"path:/a": {"k1": idx.sets["path:/a"]["k1"]}}, idx.sets)
+}
+
+func TestRedisKeyIndex(t *testing.T) {
+	cfg, server := newRedisConfigForTest(t)
+	index, err := cfg.newKeyIndex()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, index.add(ctx, "path:/a", "k1", time.Minute))
+	require.NoError(t, index.add(ctx, "path:/a", "k2", 10*time.Second))
+
+	keys, err := index.members(ctx, "path:/a")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"k1", "k2"}, keys)
+	// 만료 시간은 줄어들지 않는다
+	assert.Equal(t, time.Minute, server.TTL("path:/a"))
+
+	require.NoError(t, index.add(ctx, "path:/a*b", "k3", time.Minute))
+	require.NoError(t, index.add(ctx, "path:/ab", "k4", time.Minute))
+	names, err := index.names(ctx, "path:/a")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"path:/a", "path:/a*b", "path:/ab"}, names)
+	// 접두어의 glob 문자는 그대로 맞춘다
+	names, err = index.names(ctx, "path:/a*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"path:/a*b"}, names)
+
+	require.NoError(t, index.delete(ctx, "path:/a"))
+	assert.False(t, server.Exists("path:/a"))
+
+	// 만료되지 않는 캐시 키가 있으면 인덱스는 unboundedIndexTTL 동안 남는다
+	require.NoError(t, index.add(ctx, "path:/forever", "k5", time.Minute))
+	require.NoError(t, index.add(ctx, "path:/forever", "k6", 0))
+	assert.Equal(t, unboundedIndexTTL, server.TTL("path:/forever"))
+	require.NoError(t, index.add(ctx, "path:/forever", "k7", time.Minute))
+	assert.Equal(t, unboundedIndexTTL, server.TTL("path:/forever"))
+	server.FastForward(time.Hour)
+	keys, err = index.members(ctx, "path:/forever")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"k5", "k6", "k7"}, keys)
+	server.FastForward(unboundedIndexTTL)
+	assert.False(t, server.Exists("path:/forever"))
+}
+
+func TestSoftPurgeIndex(t *testing.T) {
+	cfg, server := newRedisConfigForTest(t)
+
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+	index, err := cfg.newKeyIndex()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now().Unix()
+	value := &CacheValue{Status: 200, Body: []byte("{}"), BodyLen: 2, Timestamp: now - 10, TTL: 60, StaleWhileRevalidate: 60}
+	require.NoError(t, marshal.Set(ctx, "soft-purge-1", value, lib_store.WithExpiration(110*time.Second)))
+	require.NoError(t, index.add(ctx, pathIndexName("/soft-purge"), "soft-purge-1", time.Minute))
+	require.NoError(t, index.add(ctx, pathIndexName("/soft-purge"), "soft-purge-gone", time.Minute))
+
+	purged, err := softPurgeIndex(ctx, marshal, index, pathIndexName("/soft-purge"), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	// 본문과 보관 기간은 그대로 두고 stale 하게 만든다
+	got := getCacheValue(ctx, marshal, "soft-purge-1")
+	require.NotNil(t, got)
+	assert.Equal(t, now, got.Purged)
+	assert.Equal(t, []byte("{}"), got.Body)
+	assert.True(t, got.stale(now))
+	assert.InDelta(t, 110*time.Second, server.TTL("soft-purge-1"), float64(2*time.Second))
+
+	// 다시 soft purge 해도 처음 시각을 지킨다
+	_, err = softPurgeIndex(ctx, marshal, index, pathIndexName("/soft-purge"), now+5)
+	require.NoError(t, err)
+	assert.Equal(t, now, getCacheValue(ctx, marshal, "soft-purge-1").Purged)
+
+	// 인덱스는 남아 있어 나중에 지울 수 있다
+	keys, err := index.members(ctx, pathIndexName("/soft-purge"))
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
+
+func TestPurgeIndex(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+	index, err := cfg.newKeyIndex()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{"purge-index-1", "purge-index-2"} {
+		require.NoError(t, marshal.Set(ctx, key, &CacheValue{Status: 200, TTL: 60}))
+		require.NoError(t, index.add(ctx, pathIndexName("/purge-index"), key, time.Minute))
+	}
+	// 스토어에서 이미 밀려난 캐시 키
+	require.NoError(t, index.add(ctx, pathIndexName("/purge-index"), "purge-index-evicted", time.Minute))
+	require.Eventually(t, func() bool {
+		return getCacheValue(ctx, marshal, "purge-index-2") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	purged, err := purgeIndex(ctx, marshal, index, pathIndexName("/purge-index"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Nil(t, getCacheValue(ctx, marshal, "purge-index-1"))
+	assert.Nil(t, getCacheValue(ctx, marshal, "purge-index-2"))
+
+	keys, err := index.members(ctx, pathIndexName("/purge-index"))
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Kong/go-pdk"
+)
+
+// invalidatesOn 은 method 의 성공 응답이 같은 경로의 캐시를 지워야 하는지 알려준다.
+// request_method 로 캐시하는 메소드는 지우지 않는다.
+//
+// See https://www.rfc-editor.org/rfc/rfc9111#section-4.4
+func (conf *Config) invalidatesOn(method string) bool {
+	if !conf.InvalidateOnUnsafe {
+		return false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return !slices.ContainsFunc(conf.RequestMethods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
+
+// indexesPaths 는 저장한 캐시 키를 경로별로 기록할지 알려준다. purge API 가 열려 있거나 다른 노드의 purge 를 받아도 기록한다.
+func (conf *Config) indexesPaths() bool {
+	return conf.InvalidateOnUnsafe || purgeEnabled() || conf.broadcastsInvalidation()
+}
+
+// indexCacheKey 는 저장한 캐시 키를 경로와 태그별 인덱스에 기록한다.
+func (conf *Config) indexCacheKey(signal CacheSignal, tags []string, storageTTL int) {
+	logger := conf.logger
+
+	var names []string
+	if conf.indexesPaths() && signal.Path != "" {
+		names = append(names, pathIndexName(signal.Path))
+	}
+	if purgeEnabled() || conf.broadcastsInvalidation() {
+		if signal.Service != "" {
+			names = append(names, serviceIndexName(signal.Service))
+		}
+		if signal.Route != "" {
+			names = append(names, routeIndexName(signal.Route))
+		}
+	}
+	for _, tag := range tags {
+		names = append(names, tagIndexName(tag))
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	index, err := conf.newKeyIndex()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create key index")
+		return
+	}
+	ttl := time.Duration(storageTTL) * time.Second
+	for _, name := range names {
+		if err := index.add(context.Background(), name, signal.CacheKeyID, ttl); err != nil {
+			logger.Error().Err(err).Msgf("Failed to index cache key '%s' by '%s'", signal.CacheKeyID, name)
+		}
+	}
+}
+
+// invalidateUnsafe 는 안전하지 않은 메소드의 2xx 응답을 받으면 요청 경로와 Location, Content-Location 경로의 캐시를 지운다.
+// 경로별 인덱스에는 쿼리와 Vary 가 다른 캐시 키도 모두 있으므로 함께 지워진다.
+func (conf *Config) invalidateUnsafe(kong *pdk.PDK, httpStatus int, path string) {
+	logger := conf.logger
+
+	if httpStatus < 200 || httpStatus >= 300 {
+		return
+	}
+
+	paths := []string{path}
+	for _, name := range []string{"Location", "Content-Location"} {
+		v, err := kong.Response.GetHeader(name)
+		if err != nil {
+			logger.Debug().Err(err).Msgf("Failed to get response header `%s`", name)
+			continue
+		}
+		if p, ok := conf.invalidationPath(kong, path, v); ok && !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+
+	for _, p := range paths {
+		// purge 를 거쳐 invalidation_channel 이 있으면 다른 노드에도 알린다
+		purged, err := conf.purge(context.Background(), purgeRequest{Path: p})
+		if err != nil {
+			logger.Error().Err(err).Msgf("Failed to invalidate path `%s`", p)
+			continue
+		}
+		logger.Debug().Msgf("%d cache entries of path `%s` are invalidated", purged, p)
+	}
+}
+
+// invalidationPath 는 Location, Content-Location 헤더 값을 요청 경로 기준으로 풀어 캐시 키의 경로로 바꾼다.
+// 다른 호스트를 가리키면 지우지 않는다.
+func (conf *Config) invalidationPath(kong *pdk.PDK, requestPath string, location string) (string, bool) {
+	if location == "" {
+		return "", false
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		conf.logger.Debug().Err(err).Msgf("Invalid location `%s`", location)
+		return "", false
+	}
+	if u.Host != "" {
+		host, err := kong.Request.GetHost()
+		if err != nil || !strings.EqualFold(u.Hostname(), host) {
+			return "", false
+		}
+	}
+
+	resolved := (&url.URL{Path: requestPath}).ResolveReference(u)
+	return conf.cacheKeyPath(resolved.EscapedPath()), true
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/Kong/go-pdk/server/kong_plugin_protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestConfig_invalidatesOn(t *testing.T) {
+	cfg := configDefault()
+	cfg.RequestMethods = []string{"GET", "HEAD", "POST"}
+	assert.False(t, cfg.invalidatesOn(http.MethodDelete))
+
+	cfg.InvalidateOnUnsafe = true
+	assert.True(t, cfg.invalidatesOn(http.MethodDelete))
+	assert.True(t, cfg.invalidatesOn(http.MethodPut))
+	assert.True(t, cfg.invalidatesOn(http.MethodPatch))
+	assert.False(t, cfg.invalidatesOn(http.MethodGet))
+	assert.False(t, cfg.invalidatesOn(http.MethodOptions))
+	// request_method 로 캐시하는 메소드는 지우지 않는다
+	assert.False(t, cfg.invalidatesOn(http.MethodPost))
+}
+
+func TestConfig_invalidationPath(t *testing.T) {
+	cfg := configDefault()
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+
+	tests := []struct {
+		name     string
+		location string
+		steps    []bridgetest.MockStep
+		want     string
+		wantOk   bool
+	}{
+		{name: "empty", location: "", wantOk: false},
+		{name: "absolute path", location: "/orders/42", want: "/orders/42", wantOk: true},
+		{name: "relative path", location: "42", want: "/orders/42", wantOk: true},
+		{
+			name:     "same host",
+			location: "https://api.example.com/orders/42?x=1",
+			steps:    []bridgetest.MockStep{{Method: "kong.request.get_host", Ret: bridge.WrapString("api.example.com")}},
+			want:     "/orders/42",
+			wantOk:   true,
+		},
+		{
+			name:     "other host",
+			location: "https://evil.example.com/orders/42",
+			steps:    []bridgetest.MockStep{{Method: "kong.request.get_host", Ret: bridge.WrapString("api.example.com")}},
+			wantOk:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kong, recorder := mockPdkSteps(t, tt.steps)
+			got, ok := cfg.invalidationPath(kong, "/orders/", tt.location)
+			recorder.assertConsumed()
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_Access_InMemory_InvalidateSignal(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.InvalidateOnUnsafe = true
+
+	kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+		{Method: "kong.request.get_method", Ret: bridge.WrapString("DELETE")},
+		{Method: "kong.request.get_path", Ret: bridge.WrapString("/orders/42")},
+		{Method: "kong.ctx.shared.set"},
+		{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Bypass")}},
+	})
+	cfg.Access(kong)
+	recorder.assertConsumed()
+}
+
+func Test_Response_InMemory_InvalidateUnsafe(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.CacheTTL = 60
+	cfg.CacheVersion = Version
+	cfg.InvalidateOnUnsafe = true
+
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// 같은 경로의 쿼리와 Vary 가 다른 캐시 키를 저장한다
+	stored := map[string]string{
+		"invalidate-order-ko":    "/orders/42",
+		"invalidate-order-en":    "/orders/42",
+		"invalidate-created":     "/orders/43",
+		"invalidate-other-order": "/orders/44",
+	}
+	for key, path := range stored {
+		signal := CacheSignal{CacheKeyID: key, CacheTTL: 60, Path: path}
+		kong, recorder := mockPdkSteps(t, responseStoreSteps(t, signal, map[string][]string{}, "{}"))
+		cfg.Response(kong)
+		recorder.assertConsumed()
+	}
+	require.Eventually(t, func() bool {
+		for key := range stored {
+			if getCacheValue(ctx, marshal, key) == nil {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	responseSteps := func(status int, location string) []bridgetest.MockStep {
+		signal := CacheSignal{CacheKeyID: pathIndexName("/orders/42"), Path: "/orders/42", Invalidate: true}
+		return append(responseSignalSteps(t, status, signal),
+			bridgetest.MockStep{Method: "kong.response.get_header", Args: bridge.WrapString("Location"), Ret: bridge.WrapString(location)},
+			bridgetest.MockStep{Method: "kong.response.get_header", Args: bridge.WrapString("Content-Location"), Ret: bridge.WrapString("")},
+		)
+	}
+
+	// 실패한 응답은 지우지 않는다
+	kong, recorder := mockPdkSteps(t, responseSignalSteps(t, http.StatusConflict, CacheSignal{CacheKeyID: pathIndexName("/orders/42"), Path: "/orders/42", Invalidate: true}))
+	cfg.Response(kong)
+	recorder.assertConsumed()
+	assert.NotNil(t, getCacheValue(ctx, marshal, "invalidate-order-ko"))
+
+	kong, recorder = mockPdkSteps(t, responseSteps(http.StatusOK, "/orders/43"))
+	cfg.Response(kong)
+	recorder.assertConsumed()
+
+	assert.Nil(t, getCacheValue(ctx, marshal, "invalidate-order-ko"))
+	assert.Nil(t, getCacheValue(ctx, marshal, "invalidate-order-en"))
+	assert.Nil(t, getCacheValue(ctx, marshal, "invalidate-created"))
+	assert.NotNil(t, getCacheValue(ctx, marshal, "invalidate-other-order"))
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strings"
+
+	"github.com/Kong/go-pdk"
+)
+
+// isJSONContentType 은 application/json 이나 application/*+json 이면 true 다.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" ||
+		strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")
+}
+
+// jsonRequestBody 는 Content-Type 이 JSON 인 요청 본문을 디코딩한다.
+// JSON 이 아니거나 파싱할 수 없으면 ok 가 false 다.
+func (conf *Config) jsonRequestBody(kong *pdk.PDK, body []byte) (v any, ok bool) {
+	logger := conf.logger
+
+	if len(body) == 0 {
+		return nil, false
+	}
+
+	contentType, err := kong.Request.GetHeader("Content-Type")
+	if err != nil {
+		logger.Debug().Err(err).Msg("Failed to get request header `Content-Type`")
+	}
+	if !isJSONContentType(contentType) {
+		return nil, false
+	}
+
+	v, err = decodeJSON(body)
+	if err != nil {
+		logger.Debug().Err(err).Msg("Request body is not a valid JSON; using the raw body")
+		return nil, false
+	}
+	return v, true
+}
+
+// decodeJSON 은 숫자를 원문 그대로 두도록 json.Number 로 디코딩한다.
+func decodeJSON(body []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// canonicalJSONBody 는 JSON 요청 본문을 공백과 키 순서에 관계없는 형태로 바꾼다.
+// JSON 이 아니거나 파싱할 수 없으면 본문을 그대로 돌려준다.
+func (conf *Config) canonicalJSONBody(kong *pdk.PDK, body []byte) []byte {
+	v, ok := conf.jsonRequestBody(kong, body)
+	if !ok {
+		return body
+	}
+
+	canonical, err := canonicalJSON(v, conf.IgnoredJSONPaths)
+	if err != nil {
+		conf.logger.Error().Err(err).Msg("Failed to encode the canonical JSON body")
+		return body
+	}
+	return canonical
+}
+
+// canonicalJSON 은 ignoredPaths 의 값을 지우고 키를 정렬해 공백 없이 다시 인코딩한다.
+// ignoredPaths 는 json_paths 와 같은 JSONPath 다.
+func canonicalJSON(v any, ignoredPaths []string) ([]byte, error) {
+	for _, expr := range ignoredPaths {
+		steps, err := parseJSONPath(expr)
+		if err != nil {
+			return nil, err
+		}
+		deleteJSONPath(v, steps)
+	}
+
+	// encoding/json 은 map 의 키를 정렬해 인코딩한다
+	return json.Marshal(v)
+}
+
+// deleteJSONPath 는 steps 가 가리키는 객체의 키를 지운다.
+// 배열의 요소는 지우면 뒤의 인덱스가 바뀌므로, 경로가 배열의 요소에서 끝나면 지우지 않는다.
+func deleteJSONPath(v any, steps []jsonPathStep) {
+	if len(steps) == 0 {
+		return
+	}
+
+	step, rest := steps[0], steps[1:]
+	switch node := v.(type) {
+	case map[string]any:
+		switch {
+		case step.wildcard:
+			for k, child := range node {
+				if len(rest) == 0 {
+					delete(node, k)
+				} else {
+					deleteJSONPath(child, rest)
+				}
+			}
+		case step.key != "":
+			if len(rest) == 0 {
+				delete(node, step.key)
+			} else if child, ok := node[step.key]; ok {
+				deleteJSONPath(child, rest)
+			}
+		}
+	case []any:
+		switch {
+		case step.wildcard:
+			for _, child := range node {
+				deleteJSONPath(child, rest)
+			}
+		case step.key == "" && step.index < len(node):
+			deleteJSONPath(node[step.index], rest)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{contentType: "application/json", want: true},
+		{contentType: "application/json; charset=utf-8", want: true},
+		{contentType: "Application/JSON", want: true},
+		{contentType: "application/vnd.api+json", want: true},
+		{contentType: "text/plain", want: false},
+		{contentType: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			assert.Equal(t, tt.want, isJSONContentType(tt.contentType))
+		})
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		ignoredPaths []string
+		want         string
+		wantErr      bool
+	}{
+		{
+			name: "whitespace and key order",
+			body: "{\n  \"b\": 1,\n  \"a\": {\"y\": true, \"x\": null}\n}",
+			want: `{"a":{"x":null,"y":true},"b":1}`,
+		},
+		{
+			name: "numbers are kept as is",
+			body: `{"big": 12345678901234567890, "float": 1.50}`,
+			want: `{"big":12345678901234567890,"float":1.50}`,
+		},
+		{
+			name:         "ignored paths",
+			body:         `{"requestId": "r-1", "meta": {"timestamp": 1, "page": 2}, "q": "x"}`,
+			ignoredPaths: []string{"$.requestId", "$['meta'].timestamp", "$.missing.path"},
+			want:         `{"meta":{"page":2},"q":"x"}`,
+		},
+		{
+			name:         "ignored paths in arrays",
+			body:         `{"items": [{"id": 1, "ts": 1}, {"id": 2, "ts": 2}]}`,
+			ignoredPaths: []string{"$.items[*].ts"},
+			want:         `{"items":[{"id":1},{"id":2}]}`,
+		},
+		{
+			name:         "ignored path of an array element",
+			body:         `{"items": [{"id": 1, "ts": 1}, {"id": 2, "ts": 2}]}`,
+			ignoredPaths: []string{"$.items[0].ts", "$.items[1]"},
+			want:         `{"items":[{"id":1},{"id":2,"ts":2}]}`,
+		},
+		{
+			name:         "ignored wildcard",
+			body:         `{"meta": {"timestamp": 1, "page": 2}, "q": "x"}`,
+			ignoredPaths: []string{"$.meta.*"},
+			want:         `{"meta":{},"q":"x"}`,
+		},
+		{
+			name:         "invalid ignored path",
+			body:         `{"requestId": "r-1"}`,
+			ignoredPaths: []string{"requestId"},
+			wantErr:      true,
+		},
+		{
+			name:    "invalid json",
+			body:    `{"a":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			v, err := decodeJSON([]byte(tt.body))
+			if err == nil {
+				got, err = canonicalJSON(v, tt.ignoredPaths)
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestConfig_canonicalJSONBody(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+	cfg.IgnoredJSONPaths = []string{"$.requestId"}
+
+	bodyFor := func(contentType string, body string) string {
+		kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+			{Method: "kong.request.get_header", Args: bridge.WrapString("Content-Type"), Ret: bridge.WrapString(contentType)},
+		})
+		got := cfg.canonicalJSONBody(kong, []byte(body))
+		recorder.assertConsumed()
+		return string(got)
+	}
+
+	assert.Equal(t,
+		bodyFor("application/json", `{"q": "x", "requestId": "r-1"}`),
+		bodyFor("application/json; charset=utf-8", `{"requestId":"r-2","q":"x"}`),
+	)
+	assert.Equal(t, `{"b": 1, "a": 2}`, bodyFor("text/plain", `{"b": 1, "a": 2}`))
+	assert.Equal(t, `{"a":`, bodyFor("application/json", `{"a":`))
+
+	// 빈 본문은 Content-Type 을 보지 않는다
+	kong, recorder := mockPdkSteps(t, nil)
+	assert.Empty(t, cfg.canonicalJSONBody(kong, nil))
+	recorder.assertConsumed()
+}
+
+func TestConfig_checkConfig_IgnoredJSONPaths(t *testing.T) {
+	conf := newInMemoryConfigForTest()
+	conf.IgnoredJSONPaths = []string{"$.requestId", "$.meta.timestamp"}
+	assert.NoError(t, conf.checkConfig())
+
+	// json_paths 와 같은 JSONPath 만 받는다
+	conf.IgnoredJSONPaths = []string{"meta.timestamp"}
+	assert.Error(t, conf.checkConfig())
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Kong/go-pdk"
+)
+
+// jsonPathStep 은 JSONPath 의 한 단계다. key 가 비어 있고 wildcard 가 아니면 배열의 index 를 뜻한다.
+type jsonPathStep struct {
+	key      string
+	index    int
+	wildcard bool
+}
+
+// parseJSONPath 는 JSONPath 의 부분 집합을 해석한다.
+//
+//   - `$.a.b`, `$['a']["b"]`: 객체의 키
+//   - `$.items[0]`: 배열의 요소
+//   - `$.items[*].id`, `$.a.*`: 모든 요소
+//
+// 재귀 탐색(`..`)과 필터 식(`?()`)은 지원하지 않는다.
+func parseJSONPath(expr string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("json path %q must start with `$`", expr)
+	}
+
+	var steps []jsonPathStep
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("json path %q has an empty key", expr)
+			case "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			default:
+				steps = append(steps, jsonPathStep{key: name})
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q has an unclosed `[`", expr)
+			}
+			selector := rest[1:end]
+			rest = rest[end+1:]
+
+			switch {
+			case selector == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+				steps = append(steps, jsonPathStep{key: selector[1 : len(selector)-1]})
+			default:
+				index, err := strconv.Atoi(selector)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("json path %q has an invalid selector `[%s]`", expr, selector)
+				}
+				steps = append(steps, jsonPathStep{index: index})
+			}
+		default:
+			return nil, fmt.Errorf("json path %q is invalid at %q", expr, rest)
+		}
+	}
+	return steps, nil
+}
+
+// evalJSONPath 는 v 에서 steps 가 가리키는 값을 모두 돌려준다. 없으면 빈 슬라이스다.
+func evalJSONPath(v any, steps []jsonPathStep) []any {
+	if len(steps) == 0 {
+		return []any{v}
+	}
+
+	step, rest := steps[0], steps[1:]
+	found := []any{}
+	switch node := v.(type) {
+	case map[string]any:
+		switch {
+		case step.wildcard:
+			// 결과 순서가 요청마다 같도록 키 순서로 모은다
+			keys := make([]string, 0, len(node))
+			for k := range node {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				found = append(found, evalJSONPath(node[k], rest)...)
+			}
+		case step.key != "":
+			if child, ok := node[step.key]; ok {
+				found = append(found, evalJSONPath(child, rest)...)
+			}
+		}
+	case []any:
+		switch {
+		case step.wildcard:
+			for _, child := range node {
+				found = append(found, evalJSONPath(child, rest)...)
+			}
+		case step.key == "" && step.index < len(node):
+			found = append(found, evalJSONPath(node[step.index], rest)...)
+		}
+	}
+	return found
+}
+
+// validJSONPaths 는 파싱할 수 없는 JSONPath 를 알리고 뺀다. option 은 로그에 남길 설정 이름이다.
+// validIgnoredQueryArgs 와 같이 checkConfig 는 디버그 모드에서만 부르므로 Init 에서 부른다.
+func (conf *Config) validJSONPaths(option string, exprs []string) []string {
+	if exprs == nil {
+		return nil
+	}
+
+	valid := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		if _, err := parseJSONPath(expr); err != nil {
+			conf.logger.Error().Err(err).Msgf("Ignoring invalid %s `%s`", option, expr)
+			continue
+		}
+		valid = append(valid, expr)
+	}
+	return valid
+}
+
+// jsonPathBody 는 JSON 요청 본문에서 jsonPaths 의 값만 뽑아 캐시 키에 쓸 본문을 만든다.
+// 경로마다 찾은 값의 배열이 순서대로 들어가므로 값이 없는 것과 null 인 것은 구분된다.
+// JSON 이 아니거나 파싱할 수 없으면 본문을 그대로 돌려준다.
+func (conf *Config) jsonPathBody(kong *pdk.PDK, jsonPaths []string, body []byte) []byte {
+	logger := conf.logger
+
+	v, ok := conf.jsonRequestBody(kong, body)
+	if !ok {
+		return body
+	}
+
+	values := make([][]any, 0, len(jsonPaths))
+	for _, expr := range jsonPaths {
+		steps, err := parseJSONPath(expr)
+		if err != nil {
+			logger.Error().Err(err).Msg("Invalid json path")
+			return body
+		}
+		values = append(values, evalJSONPath(v, steps))
+	}
+
+	extracted, err := json.Marshal(values)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encode json path values")
+		return body
+	}
+	return extracted
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    []jsonPathStep
+		wantErr bool
+	}{
+		{name: "root", expr: "$", want: nil},
+		{name: "dot keys", expr: "$.a.b", want: []jsonPathStep{{key: "a"}, {key: "b"}}},
+		{name: "bracket keys", expr: `$['a']["b.c"]`, want: []jsonPathStep{{key: "a"}, {key: "b.c"}}},
+		{name: "index and wildcard", expr: "$.items[1][*].id", want: []jsonPathStep{{key: "items"}, {index: 1}, {wildcard: true}, {key: "id"}}},
+		{name: "dot wildcard", expr: "$.a.*", want: []jsonPathStep{{key: "a"}, {wildcard: true}}},
+		{name: "missing root", expr: "a.b", wantErr: true},
+		{name: "recursive descent", expr: "$..a", wantErr: true},
+		{name: "unclosed bracket", expr: "$.a[0", wantErr: true},
+		{name: "negative index", expr: "$.a[-1]", wantErr: true},
+		{name: "filter expression", expr: "$.a[?(@.b)]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJSONPath(tt.expr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvalJSONPath(t *testing.T) {
+	v, err := decodeJSON([]byte(`{
+		"query": "shoes",
+		"page": 1,
+		"none": null,
+		"filters": [{"type": "color", "value": "red"}, {"type": "size", "value": 270}],
+		"sort": {"b": 2, "a": 1}
+	}`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "$.query", want: `["shoes"]`},
+		{expr: "$.page", want: `[1]`},
+		{expr: "$.none", want: `[null]`},
+		{expr: "$.missing", want: `[]`},
+		{expr: "$.filters[1].value", want: `[270]`},
+		{expr: "$.filters[5].value", want: `[]`},
+		{expr: "$.filters[*].type", want: `["color","size"]`},
+		{expr: "$.sort.*", want: `[1,2]`},
+		{expr: "$.query[0]", want: `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			steps, err := parseJSONPath(tt.expr)
+			require.NoError(t, err)
+			got, err := json.Marshal(evalJSONPath(v, steps))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestConfig_jsonPathBody(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+	jsonPaths := []string{"$.query", "$.filters[*].type"}
+
+	bodyFor := func(contentType string, body string) string {
+		kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+			{Method: "kong.request.get_header", Args: bridge.WrapString("Content-Type"), Ret: bridge.WrapString(contentType)},
+		})
+		got := cfg.jsonPathBody(kong, jsonPaths, []byte(body))
+		recorder.assertConsumed()
+		return string(got)
+	}
+
+	got := bodyFor("application/json", `{"query": "shoes", "requestId": "r-1", "filters": [{"type": "color"}], "blob": "..."}`)
+	assert.Equal(t, `[["shoes"],["color"]]`, got)
+	assert.Equal(t, got, bodyFor("application/json", `{"filters":[{"type":"color","value":"red"}],"query":"shoes"}`))
+	assert.NotEqual(t, got, bodyFor("application/json", `{"query": "boots", "filters": [{"type": "color"}]}`))
+	assert.Equal(t, `query=shoes`, bodyFor("application/x-www-form-urlencoded", `query=shoes`))
+}
+
+func TestConfig_checkConfig_JSONPaths(t *testing.T) {
+	conf := newInMemoryConfigForTest()
+	conf.Filters = []Filter{{Name: "search", Rules: []Rule{{Regexp: "^/search"}}, JSONPaths: []string{"$.query"}}}
+	assert.NoError(t, conf.checkConfig())
+
+	conf.Filters[0].JSONPaths = []string{"query"}
+	assert.Error(t, conf.checkConfig())
+}
+
+func TestConfig_Init_JSONPaths(t *testing.T) {
+	conf := newInMemoryConfigForTest()
+	conf.IgnoredJSONPaths = []string{"$.requestId", "requestId"}
+	conf.Filters = []Filter{
+		{Name: "search", Rules: []Rule{{Regexp: "^/search"}}, JSONPaths: []string{"$.query", "$.filters["}},
+		{Name: "default", Rules: []Rule{{Regexp: "^/"}}},
+	}
+	conf.Init()
+	defer conf.Close()
+
+	// 디버그 모드가 아니어도 잘못된 JSONPath 는 Init 에서 뺀다
+	assert.Equal(t, []string{"$.requestId"}, conf.IgnoredJSONPaths)
+	assert.Equal(t, []string{"$.query"}, conf.Filters[0].JSONPaths)
+	assert.Nil(t, conf.Filters[1].JSONPaths)
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/umisama/go-regexpcache"
+)
+
+// cacheKeyPath 는 캐시 키에 넣을 경로를 정규화한다.
+//
+//   - normalize_path: 비예약 문자의 퍼센트 인코딩을 풀고, 중복된 슬래시와 끝의 슬래시를 없앤다
+//   - ignore_uri_case: 대소문자를 구분하지 않는다
+func (conf *Config) cacheKeyPath(path string) string {
+	if conf.NormalizePath {
+		path = normalizePath(path)
+	}
+	if conf.IgnoreURICase {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// normalizePath 는 같은 자원을 가리키는 경로가 같은 문자열이 되도록 정리한다.
+//
+// See https://www.rfc-editor.org/rfc/rfc3986#section-6.2.2
+func normalizePath(path string) string {
+	path = decodeUnreserved(path)
+
+	var b strings.Builder
+	b.Grow(len(path))
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(path[i])
+	}
+	path = b.String()
+
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+// decodeUnreserved 는 비예약 문자(ALPHA, DIGIT, "-", ".", "_", "~")의 퍼센트 인코딩만 풀고,
+// 나머지 퍼센트 인코딩은 16진수를 대문자로 맞춘다.
+func decodeUnreserved(path string) string {
+	if !strings.Contains(path, "%") {
+		return path
+	}
+
+	var b strings.Builder
+	b.Grow(len(path))
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+2 >= len(path) || !isHex(path[i+1]) || !isHex(path[i+2]) {
+			b.WriteByte(path[i])
+			continue
+		}
+
+		c := unhex(path[i+1])<<4 | unhex(path[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(path[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// cacheKeyQuery 는 캐시 키에 넣을 쿼리 인자를 고른다.
+//
+//   - query_args: 비어 있지 않으면 나열한 인자만 넣는다
+//   - ignored_query_args: 이름이 정규식과 일치하는 인자는 뺀다 (예: `utm_.*`, `fbclid`)
+//   - normalize_query: 값을 정렬하고 빈 값은 뺀다. `?a=1&a=2` 와 `?a=2&a=1`, `?a=` 와 `?a` 가 같은 키가 된다
+func (f *Filter) cacheKeyQuery(args map[string][]string) map[string][]string {
+	normalize := f.NormalizeQuery != nil && *f.NormalizeQuery
+	if len(f.QueryArgs) == 0 && len(f.IgnoredQueryArgs) == 0 && !normalize {
+		return args
+	}
+
+	filtered := make(map[string][]string, len(args))
+	for name, values := range args {
+		if len(f.QueryArgs) > 0 && !slices.Contains(f.QueryArgs, name) {
+			continue
+		}
+		if f.ignoredQueryArg(name) {
+			continue
+		}
+
+		if normalize {
+			values = slices.DeleteFunc(slices.Clone(values), func(v string) bool { return v == "" })
+			if len(values) == 0 {
+				continue
+			}
+			slices.Sort(values)
+		}
+		filtered[name] = values
+	}
+	return filtered
+}
+
+func (f *Filter) ignoredQueryArg(name string) bool {
+	for _, pattern := range f.IgnoredQueryArgs {
+		// 잘못된 정규식은 Init 에서 알리고 뺐으므로 여기서는 건너뛰기만 한다
+		if r, err := ignoredQueryArgRegexp(pattern); err == nil && r.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredQueryArgRegexp 는 ignored_query_args 의 정규식을 인자 이름 전체에 맞추도록 컴파일한다.
+func ignoredQueryArgRegexp(pattern string) (*regexp.Regexp, error) {
+	return regexpcache.Compile("^(?:" + pattern + ")$")
+}
+
+// validIgnoredQueryArgs 는 컴파일할 수 없는 정규식을 알리고 뺀다.
+// checkConfig 는 디버그 모드에서만 부르므로, 요청에서 panic 이 나지 않도록 Init 에서 부른다.
+func (conf *Config) validIgnoredQueryArgs(patterns []string) []string {
+	if patterns == nil {
+		return nil
+	}
+
+	valid := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, err := ignoredQueryArgRegexp(pattern); err != nil {
+			conf.logger.Error().Err(err).Msgf("Ignoring invalid ignored_query_args pattern `%s`", pattern)
+			continue
+		}
+		valid = append(valid, pattern)
+	}
+	return valid
+}
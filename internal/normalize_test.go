@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "root", path: "/", want: "/"},
+		{name: "already normalized", path: "/api/users", want: "/api/users"},
+		{name: "trailing slash", path: "/api/users/", want: "/api/users"},
+		{name: "duplicate slashes", path: "//api///users//", want: "/api/users"},
+		{name: "unreserved characters are decoded", path: "/api/%7Euser%2Dname%5F1", want: "/api/~user-name_1"},
+		{name: "reserved characters stay encoded", path: "/api/a%2fb%3F", want: "/api/a%2Fb%3F"},
+		{name: "incomplete escape", path: "/api/100%", want: "/api/100%"},
+		{name: "invalid escape", path: "/api/%zz", want: "/api/%zz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizePath(tt.path))
+		})
+	}
+}
+
+func TestConfig_cacheKeyPath(t *testing.T) {
+	tests := []struct {
+		name          string
+		ignoreURICase bool
+		normalizePath bool
+		path          string
+		want          string
+	}{
+		{name: "disabled", path: "/API/users/", want: "/API/users/"},
+		{name: "ignore_uri_case", ignoreURICase: true, path: "/API/users/", want: "/api/users/"},
+		{name: "normalize_path", normalizePath: true, path: "/API//users/", want: "/API/users"},
+		{name: "both", ignoreURICase: true, normalizePath: true, path: "/API//%55sers/", want: "/api/users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := configDefault()
+			conf.IgnoreURICase = tt.ignoreURICase
+			conf.NormalizePath = tt.normalizePath
+			assert.Equal(t, tt.want, conf.cacheKeyPath(tt.path))
+		})
+	}
+}
+
+func TestFilter_cacheKeyQuery(t *testing.T) {
+	enabled := true
+	args := map[string][]string{
+		"page":       {"2"},
+		"tag":        {"b", "a"},
+		"utm_source": {"newsletter"},
+		"empty":      {""},
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   map[string][]string
+	}{
+		{
+			name:   "disabled",
+			filter: Filter{},
+			want:   args,
+		},
+		{
+			name:   "query_args",
+			filter: Filter{QueryArgs: []string{"page", "missing"}},
+			want:   map[string][]string{"page": {"2"}},
+		},
+		{
+			name:   "ignored_query_args",
+			filter: Filter{IgnoredQueryArgs: []string{"utm_.*", "empty"}},
+			want:   map[string][]string{"page": {"2"}, "tag": {"b", "a"}},
+		},
+		{
+			name:   "ignored_query_args are anchored",
+			filter: Filter{IgnoredQueryArgs: []string{"utm"}},
+			want:   args,
+		},
+		{
+			name:   "normalize_query",
+			filter: Filter{NormalizeQuery: &enabled},
+			want:   map[string][]string{"page": {"2"}, "tag": {"a", "b"}, "utm_source": {"newsletter"}},
+		},
+		{
+			name:   "invalid ignored_query_args are skipped",
+			filter: Filter{IgnoredQueryArgs: []string{"utm_(", "page"}},
+			want:   map[string][]string{"tag": {"b", "a"}, "utm_source": {"newsletter"}, "empty": {""}},
+		},
+		{
+			name:   "query_args and ignored_query_args",
+			filter: Filter{QueryArgs: []string{"page", "utm_source"}, IgnoredQueryArgs: []string{"utm_.*"}},
+			want:   map[string][]string{"page": {"2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.cacheKeyQuery(args))
+		})
+	}
+
+	// 원래 값은 바꾸지 않는다
+	assert.Equal(t, []string{"b", "a"}, args["tag"])
+}
+
+func TestConfig_checkConfig_IgnoredQueryArgs(t *testing.T) {
+	conf := newInMemoryConfigForTest()
+	conf.IgnoredQueryArgs = []string{"utm_.*"}
+	assert.NoError(t, conf.checkConfig())
+
+	conf.IgnoredQueryArgs = []string{"utm_("}
+	assert.Error(t, conf.checkConfig())
+
+	conf.IgnoredQueryArgs = nil
+	conf.Filters = []Filter{{Name: "api", Rules: []Rule{{Regexp: "^/api"}}, IgnoredQueryArgs: []string{"utm_.*"}}}
+	assert.NoError(t, conf.checkConfig())
+
+	conf.Filters[0].IgnoredQueryArgs = []string{"["}
+	assert.Error(t, conf.checkConfig())
+}
+
+func TestConfig_Init_IgnoredQueryArgs(t *testing.T) {
+	conf := newInMemoryConfigForTest()
+	conf.IgnoredQueryArgs = []string{"utm_.*", "utm_("}
+	conf.Filters = []Filter{
+		{Name: "invalid", Rules: []Rule{{Regexp: "^/api"}}, IgnoredQueryArgs: []string{"["}},
+		{Name: "inherited", Rules: []Rule{{Regexp: "^/"}}},
+	}
+	conf.Init()
+	defer conf.Close()
+
+	// 디버그 모드가 아니어도 잘못된 정규식은 Init 에서 뺀다
+	assert.Equal(t, []string{"utm_.*"}, conf.IgnoredQueryArgs)
+	assert.Empty(t, conf.Filters[0].IgnoredQueryArgs)
+	assert.Equal(t, []string{"utm_.*"}, conf.Filters[1].IgnoredQueryArgs)
+}
+
+func TestConfig_Init_NormalizeQuery(t *testing.T) {
+	disabled := false
+	conf := newInMemoryConfigForTest()
+	conf.NormalizeQuery = true
+	conf.Filters = []Filter{
+		{Name: "inherited", Rules: []Rule{{Regexp: "^/"}}},
+		{Name: "disabled", Rules: []Rule{{Regexp: "^/raw"}}, NormalizeQuery: &disabled},
+	}
+	conf.Init()
+	defer conf.Close()
+
+	args := map[string][]string{"tag": {"b", "a"}}
+	assert.Equal(t, map[string][]string{"tag": {"a", "b"}}, conf.Filters[0].cacheKeyQuery(args))
+	// filter 에서 false 로 두면 Config 에서 켜도 끈다
+	assert.Equal(t, args, conf.Filters[1].cacheKeyQuery(args))
+	filter := conf.defaultFilter()
+	assert.Equal(t, map[string][]string{"tag": {"a", "b"}}, filter.cacheKeyQuery(args))
+}
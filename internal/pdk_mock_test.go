@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Kong/go-pdk"
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/Kong/go-pdk/client"
+	kongctx "github.com/Kong/go-pdk/ctx"
+	"github.com/Kong/go-pdk/ip"
+	"github.com/Kong/go-pdk/nginx"
+	"github.com/Kong/go-pdk/node"
+	"github.com/Kong/go-pdk/request"
+	"github.com/Kong/go-pdk/response"
+	"github.com/Kong/go-pdk/router"
+	"github.com/Kong/go-pdk/server/kong_plugin_protocol"
+	"github.com/Kong/go-pdk/service"
+	service_request "github.com/Kong/go-pdk/service/request"
+	service_response "github.com/Kong/go-pdk/service/response"
+	"github.com/unchartedsky/sonic-boom/test"
+	"google.golang.org/protobuf/proto"
+)
+
+// stepRecorder 는 bridgetest.Mock 과 같이 순서대로 MockStep 을 검사하되, 몇 단계까지 소비되었는지 기록한다.
+// bridgetest.Mock 은 남은 단계가 있어도 알려주지 않기 때문에 Access/Response 흐름을 끝까지 검증할 때 쓴다.
+type stepRecorder struct {
+	t     *testing.T
+	steps []bridgetest.MockStep
+
+	mu    sync.Mutex
+	pos   int
+	calls []recordedCall
+}
+
+type recordedCall struct {
+	Method string
+	Args   []byte
+}
+
+func (r *stepRecorder) Handle(method string, args []byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pos >= len(r.steps) {
+		r.t.Errorf("unexpected call %s after %d steps", method, len(r.steps))
+		return nil
+	}
+
+	stp := r.steps[r.pos]
+	r.pos++
+	r.calls = append(r.calls, recordedCall{Method: method, Args: args})
+
+	if stp.Method != method {
+		r.t.Errorf("step %d, expected method %s, found %s", r.pos-1, stp.Method, method)
+		return nil
+	}
+
+	if stp.Args != nil {
+		got := stp.Args.ProtoReflect().New().Interface()
+		if err := proto.Unmarshal(args, got); err != nil {
+			r.t.Errorf("step %d, Unmarshal(args): %s", r.pos-1, err)
+			return nil
+		}
+		if !proto.Equal(stp.Args, got) {
+			r.t.Errorf("step %d, %s expected %v, received %v", r.pos-1, method, stp.Args, got)
+			return nil
+		}
+	}
+
+	if stp.Ret == nil {
+		return nil
+	}
+	ret, err := proto.Marshal(stp.Ret)
+	if err != nil {
+		r.t.Errorf("step %d, Marshal(ret): %s", r.pos-1, err)
+		return nil
+	}
+	return ret
+}
+
+// Errorf 는 연결이 닫힐 때도 불리므로 무시한다.
+func (r *stepRecorder) Errorf(string, ...interface{}) {}
+
+func (r *stepRecorder) IsRunning() bool { return true }
+
+func (r *stepRecorder) SubscribeStatusChange(chan<- string) {}
+
+func (r *stepRecorder) assertConsumed() {
+	r.t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pos != len(r.steps) {
+		r.t.Errorf("%d of %d steps are consumed, next step is %s", r.pos, len(r.steps), r.steps[r.pos].Method)
+	}
+}
+
+// exitArgs 는 마지막 kong.response.exit 호출의 인자를 돌려준다.
+func (r *stepRecorder) exitArgs() *kong_plugin_protocol.ExitArgs {
+	r.t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.calls) - 1; i >= 0; i-- {
+		if r.calls[i].Method != "kong.response.exit" {
+			continue
+		}
+		out := &kong_plugin_protocol.ExitArgs{}
+		if err := proto.Unmarshal(r.calls[i].Args, out); err != nil {
+			r.t.Fatalf("Unmarshal(exit args): %s", err)
+		}
+		return out
+	}
+	r.t.Fatalf("kong.response.exit is not called")
+	return nil
+}
+
+// responseHeader 는 kong.response.set_header 로 설정된 마지막 값을 돌려준다.
+func (r *stepRecorder) responseHeader(name string) string {
+	r.t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.calls) - 1; i >= 0; i-- {
+		if r.calls[i].Method != "kong.response.set_header" {
+			continue
+		}
+		kv := &kong_plugin_protocol.KV{}
+		if err := proto.Unmarshal(r.calls[i].Args, kv); err != nil {
+			r.t.Fatalf("Unmarshal(set_header args): %s", err)
+		}
+		if kv.K == name {
+			return kv.V.GetStringValue()
+		}
+	}
+	return ""
+}
+
+func mockPdkSteps(t *testing.T, steps []bridgetest.MockStep) (*pdk.PDK, *stepRecorder) {
+	recorder := &stepRecorder{t: t, steps: steps}
+	b := bridge.New(bridgetest.MockFunc(recorder))
+
+	return &pdk.PDK{
+		Client:          client.Client{PdkBridge: b},
+		Ctx:             kongctx.Ctx{PdkBridge: b},
+		Log:             test.MockLogDefault(),
+		Nginx:           nginx.Nginx{PdkBridge: b},
+		Request:         request.Request{PdkBridge: b},
+		Response:        response.Response{PdkBridge: b},
+		Router:          router.Router{PdkBridge: b},
+		IP:              ip.Ip{PdkBridge: b},
+		Node:            node.Node{PdkBridge: b},
+		Service:         service.Service{PdkBridge: b},
+		ServiceRequest:  service_request.Request{PdkBridge: b},
+		ServiceResponse: service_response.Response{PdkBridge: b},
+	}, recorder
+}
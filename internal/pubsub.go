@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// invalidationOrigin 은 이 프로세스가 보낸 메시지를 가려내는 값이다. 보낸 노드는 이미 지웠으므로 받지 않는다
+	invalidationOrigin = newInvalidationOrigin()
+
+	// invalidation_channel 별로 하나씩 구독한다
+	invalidationSubscribers sync.Map // map[string]*invalidationSubscriber
+	// invalidationSubscribersMu 는 구독을 시작하고 끝내는 일을 나란히 하지 않도록 막는다
+	invalidationSubscribersMu sync.Mutex
+)
+
+func newInvalidationOrigin() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// invalidationMessage 는 Redis pub/sub 으로 다른 Kong 노드에 알리는 purge 요청이다.
+type invalidationMessage struct {
+	Origin  string `json:"origin"`
+	Key     string `json:"key,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Regex   string `json:"regex,omitempty"`
+	Service string `json:"service,omitempty"`
+	Route   string `json:"route,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Soft    bool   `json:"soft,omitempty"`
+	// Keys 는 tiered 전략에서 보낸 노드가 찾은 캐시 키다. 받은 노드는 L1 에서 지운다
+	Keys []string `json:"keys,omitempty"`
+}
+
+func newInvalidationMessage(req purgeRequest, keys []string) invalidationMessage {
+	msg := invalidationMessage{
+		Origin:  invalidationOrigin,
+		Key:     req.Key,
+		Path:    req.Path,
+		Prefix:  req.Prefix,
+		Service: req.Service,
+		Route:   req.Route,
+		Tag:     req.Tag,
+		Soft:    req.Soft,
+		Keys:    keys,
+	}
+	if req.Regex != nil {
+		msg.Regex = req.Regex.String()
+	}
+	return msg
+}
+
+func (msg invalidationMessage) purgeRequest() (purgeRequest, error) {
+	req := purgeRequest{
+		Key:     msg.Key,
+		Path:    msg.Path,
+		Prefix:  msg.Prefix,
+		Service: msg.Service,
+		Route:   msg.Route,
+		Tag:     msg.Tag,
+		Soft:    msg.Soft,
+	}
+	if msg.Regex != "" {
+		re, err := regexp.Compile(msg.Regex)
+		if err != nil {
+			return req, err
+		}
+		req.Regex = re
+	}
+	return req, nil
+}
+
+// broadcastsInvalidation 은 다른 노드에 purge 를 알리고 받을지 알려준다. 노드마다 따로 캐시하는 in-memory 와 tiered 전략에서만 쓴다.
+func (conf *Config) broadcastsInvalidation() bool {
+	return conf.InvalidationChannel != "" && (conf.Strategy == "in-memory" || conf.Strategy == "tiered")
+}
+
+// invalidationRedisConfig 는 pub/sub 에 쓸 Redis 의 설정값이다. in-memory 전략은 invalidation_redis 가 가리키는 Redis 설정을 쓴다.
+func (conf *Config) invalidationRedisConfig() *Config {
+	if conf.redisStrategy() != "" {
+		return conf
+	}
+	c := *conf
+	c.Strategy = conf.InvalidationRedis
+	c.rt = nil
+	return &c
+}
+
+// l1Keys 는 tiered 전략에서 req 에 맞는 캐시 키를 찾는다. in-memory 전략은 받은 노드가 자기 인덱스에서 찾으므로 nil 을 돌려준다.
+func (conf *Config) l1Keys(ctx context.Context, req purgeRequest) ([]string, error) {
+	if conf.Strategy != "tiered" {
+		return nil, nil
+	}
+	if req.Key != "" {
+		return []string{req.Key}, nil
+	}
+
+	names, err := conf.purgeIndexNames(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	index, err := conf.newKeyIndex()
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, name := range names {
+		members, err := index.members(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, members...)
+	}
+	return keys, nil
+}
+
+// publishInvalidation 은 invalidation_channel 로 purge 요청을 보낸다.
+func (conf *Config) publishInvalidation(ctx context.Context, req purgeRequest, keys []string) error {
+	payload, err := json.Marshal(newInvalidationMessage(req, keys))
+	if err != nil {
+		return err
+	}
+	client, err := conf.invalidationRedisConfig().newRedisClient()
+	if err != nil {
+		return err
+	}
+	return client.Publish(ctx, conf.InvalidationChannel, payload).Err()
+}
+
+// invalidationSubscriber 는 invalidation_channel 하나를 구독하고, 받은 purge 요청을 이 노드의 스토어에 적용한다.
+type invalidationSubscriber struct {
+	// 같은 채널을 쓰는 설정값. purgeTargets 와 같이 스토어별로 하나씩 기억한다
+	targets sync.Map // map[uint64]*Config
+	cancel  context.CancelFunc
+	// logger 는 targets 가운데 하나의 logger 다. 그 설정값을 치우면 남은 설정값의 logger 로 바꾼다
+	logger atomic.Pointer[Logger]
+}
+
+// subscribeInvalidation 은 이 설정값의 invalidation_channel 을 구독한다. 채널별 구독은 프로세스에서 한 번만 시작한다.
+// configRuntime 이 있으면 구독에 더한 뒤로는 아무것도 하지 않는다.
+func (conf *Config) subscribeInvalidation() {
+	if !conf.broadcastsInvalidation() || conf.rt != nil && conf.rt.subscribed.Load() {
+		return
+	}
+	logger := conf.logger
+
+	hash, err := conf.purgeScopeHash()
+	if err != nil {
+		logger.Error().Err(err).Msg("hashing error")
+		return
+	}
+	redisConf := conf.invalidationRedisConfig()
+	target, err := conf.invalidationRedisTarget(redisConf)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to subscribe invalidation channel")
+		return
+	}
+
+	invalidationSubscribersMu.Lock()
+	defer invalidationSubscribersMu.Unlock()
+
+	name := target + " " + conf.InvalidationChannel
+	if actual, ok := invalidationSubscribers.Load(name); ok {
+		actual.(*invalidationSubscriber).targets.LoadOrStore(hash, conf)
+		conf.markSubscribed()
+		return
+	}
+
+	// 구독 연결은 오래 쉬므로 redisClients 에서 닫히지 않도록 따로 만든다
+	client, err := redisConf.buildRedisClient()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to subscribe invalidation channel")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	subscriber := &invalidationSubscriber{cancel: cancel}
+	subscriber.targets.Store(hash, conf)
+	subscriber.logger.Store(logger)
+	invalidationSubscribers.Store(name, subscriber)
+	conf.markSubscribed()
+	go subscriber.run(ctx, client, conf.InvalidationChannel)
+}
+
+// invalidationRedisTarget 은 redisConf 가 가리키는 Redis 다. configRuntime 이 있으면 만들 때 구한 값을 돌려준다.
+func (conf *Config) invalidationRedisTarget(redisConf *Config) (string, error) {
+	if conf.rt != nil {
+		return conf.rt.invalidationTarget, conf.rt.invalidationErr
+	}
+	_, target, err := redisConf.redisClientKey()
+	return target, err
+}
+
+func (conf *Config) markSubscribed() {
+	if conf.rt != nil {
+		conf.rt.subscribed.Store(true)
+	}
+}
+
+// unsubscribeInvalidation 은 치우는 설정값을 구독에서 뺀다.
+// 남은 설정값이 없는 채널은 구독을 끝내고, 남았다면 닫힐 logger 대신 남은 설정값의 logger 를 쓴다.
+func (conf *Config) unsubscribeInvalidation() {
+	invalidationSubscribersMu.Lock()
+	defer invalidationSubscribersMu.Unlock()
+
+	invalidationSubscribers.Range(func(name, value any) bool {
+		subscriber := value.(*invalidationSubscriber)
+		var remaining *Config
+		subscriber.targets.Range(func(hash, target any) bool {
+			if target == conf {
+				subscriber.targets.Delete(hash)
+			} else if remaining == nil {
+				remaining = target.(*Config)
+			}
+			return true
+		})
+		if remaining == nil {
+			subscriber.cancel()
+			invalidationSubscribers.Delete(name)
+			return true
+		}
+		if subscriber.logger.Load() == conf.logger {
+			subscriber.logger.Store(remaining.logger)
+		}
+		return true
+	})
+}
+
+// run 은 ctx 가 끝날 때까지 메시지를 받는다. go-redis 가 끊어진 연결을 다시 구독한다.
+func (s *invalidationSubscriber) run(ctx context.Context, client redis.UniversalClient, channel string) {
+	pubsub := client.Subscribe(ctx, channel)
+	defer func() {
+		_ = pubsub.Close()
+		_ = client.Close()
+	}()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			logger := s.logger.Load()
+			var msg invalidationMessage
+			if err := json.Unmarshal([]byte(message.Payload), &msg); err != nil {
+				logger.Error().Err(err).Msgf("Invalid invalidation message: %s", message.Payload)
+				continue
+			}
+			if msg.Origin == invalidationOrigin {
+				continue
+			}
+			s.apply(ctx, msg, logger)
+		}
+	}
+}
+
+// apply 는 받은 purge 요청을 이 노드에 적용한다.
+// in-memory 전략은 자기 인덱스에서 찾아 지우고, tiered 전략은 L2 는 보낸 노드가 지웠으므로 L1 에서만 지운다.
+func (s *invalidationSubscriber) apply(ctx context.Context, msg invalidationMessage, logger *Logger) {
+	req, err := msg.purgeRequest()
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid invalidation message")
+		return
+	}
+
+	s.targets.Range(func(_, value any) bool {
+		conf := value.(*Config)
+		switch conf.Strategy {
+		case "in-memory":
+			purged, err := conf.purgeLocal(ctx, req)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to apply invalidation message")
+				return true
+			}
+			logger.Debug().Msgf("%d cache entries are invalidated by another node", purged)
+		case "tiered":
+			client, err := conf.ristrettoClient()
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to apply invalidation message")
+				return true
+			}
+			for _, key := range msg.Keys {
+				client.Del(key)
+			}
+			logger.Debug().Msgf("%d L1 cache entries are invalidated by another node", len(msg.Keys))
+		}
+		return true
+	})
+}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPubSubConfigForTest 는 miniredis 의 채널로 purge 를 주고받는 설정값이다.
+func newPubSubConfigForTest(t *testing.T, strategy string) (*Config, *miniredis.Miniredis) {
+	cfg, server := newRedisConfigForTest(t)
+	cfg.Strategy = strategy
+	cfg.InvalidationChannel = "sonic-boom:invalidation:" + t.Name()
+
+	t.Cleanup(func() {
+		invalidationSubscribers.Range(func(name, value any) bool {
+			value.(*invalidationSubscriber).cancel()
+			invalidationSubscribers.Delete(name)
+			return true
+		})
+	})
+	return cfg, server
+}
+
+func publishForTest(t *testing.T, server *miniredis.Miniredis, channel string, msg invalidationMessage) {
+	payload, err := json.Marshal(msg)
+	require.NoError(t, err)
+	server.Publish(channel, string(payload))
+}
+
+func TestInvalidationMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		req  purgeRequest
+	}{
+		{name: "key", req: purgeRequest{Key: "k1"}},
+		{name: "tag", req: purgeRequest{Tag: "product-42", Soft: true}},
+		{name: "prefix", req: purgeRequest{Prefix: "/products/"}},
+		{name: "regex", req: purgeRequest{Regex: regexp.MustCompile(`^/products/[0-9]+$`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newInvalidationMessage(tt.req, []string{"k1"})
+			assert.Equal(t, invalidationOrigin, msg.Origin)
+
+			payload, err := json.Marshal(msg)
+			require.NoError(t, err)
+			var decoded invalidationMessage
+			require.NoError(t, json.Unmarshal(payload, &decoded))
+			assert.Equal(t, []string{"k1"}, decoded.Keys)
+
+			got, err := decoded.purgeRequest()
+			require.NoError(t, err)
+			assert.Equal(t, tt.req, got)
+		})
+	}
+
+	_, err := invalidationMessage{Regex: "("}.purgeRequest()
+	assert.Error(t, err)
+}
+
+func TestConfig_broadcastsInvalidation(t *testing.T) {
+	tests := []struct {
+		strategy string
+		channel  string
+		want     bool
+	}{
+		{strategy: "in-memory", channel: "c", want: true},
+		{strategy: "tiered", channel: "c", want: true},
+		{strategy: "redis", channel: "c", want: false},
+		{strategy: "redis-cluster", channel: "c", want: false},
+		{strategy: "in-memory", channel: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy+"/"+tt.channel, func(t *testing.T) {
+			conf := &Config{Strategy: tt.strategy, InvalidationChannel: tt.channel}
+			assert.Equal(t, tt.want, conf.broadcastsInvalidation())
+		})
+	}
+}
+
+func TestSubscribeInvalidation_InMemory(t *testing.T) {
+	conf, server := newPubSubConfigForTest(t, "in-memory")
+	ctx := context.Background()
+
+	_, marshal, err := conf.newCacheManager(60)
+	require.NoError(t, err)
+	for _, signal := range []CacheSignal{
+		{CacheKeyID: "pubsub-own", Path: "/pubsub/own"},
+		{CacheKeyID: "pubsub-tagged", Path: "/pubsub/tagged"},
+	} {
+		require.NoError(t, marshal.Set(ctx, signal.CacheKeyID, &CacheValue{Status: http.StatusOK, Timestamp: time.Now().Unix(), TTL: 60}))
+		conf.indexCacheKey(signal, []string{"pubsub-tag"}, 60)
+	}
+	client, err := conf.ristrettoClient()
+	require.NoError(t, err)
+	client.Wait()
+
+	conf.subscribeInvalidation()
+	// 같은 채널은 한 번만 구독한다
+	conf.subscribeInvalidation()
+	require.Eventually(t, func() bool {
+		return server.PubSubNumSub(conf.InvalidationChannel)[conf.InvalidationChannel] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// 이 노드가 보낸 메시지는 무시한다
+	publishForTest(t, server, conf.InvalidationChannel, invalidationMessage{Origin: invalidationOrigin, Key: "pubsub-own"})
+	// 다른 노드의 purge 는 이 노드의 인덱스에서 찾아 지운다
+	publishForTest(t, server, conf.InvalidationChannel, invalidationMessage{Origin: "other", Path: "/pubsub/tagged"})
+	require.Eventually(t, func() bool {
+		return getCacheValue(ctx, marshal, "pubsub-tagged") == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.NotNil(t, getCacheValue(ctx, marshal, "pubsub-own"))
+
+	publishForTest(t, server, conf.InvalidationChannel, invalidationMessage{Origin: "other", Tag: "pubsub-tag"})
+	require.Eventually(t, func() bool {
+		return getCacheValue(ctx, marshal, "pubsub-own") == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSubscribeInvalidation_Tiered(t *testing.T) {
+	conf, server := newPubSubConfigForTest(t, "tiered")
+	ctx := context.Background()
+
+	_, marshal, err := conf.newCacheManager(60)
+	require.NoError(t, err)
+	key := "pubsub-tiered"
+	require.NoError(t, marshal.Set(ctx, key, &CacheValue{Status: http.StatusOK, Timestamp: time.Now().Unix(), TTL: 60}))
+	client, err := conf.ristrettoClient()
+	require.NoError(t, err)
+	client.Wait()
+
+	conf.subscribeInvalidation()
+	require.Eventually(t, func() bool {
+		return server.PubSubNumSub(conf.InvalidationChannel)[conf.InvalidationChannel] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// 다른 노드가 L2 에서 지우면 이 노드의 L1 에만 남는다
+	server.Del(key)
+	require.NotNil(t, getCacheValue(ctx, marshal, key))
+
+	publishForTest(t, server, conf.InvalidationChannel, invalidationMessage{Origin: "other", Tag: "unused", Keys: []string{key}})
+	require.Eventually(t, func() bool {
+		return getCacheValue(ctx, marshal, key) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConfig_purge_PublishesInvalidation(t *testing.T) {
+	conf, server := newPubSubConfigForTest(t, "tiered")
+	ctx := context.Background()
+
+	_, marshal, err := conf.newCacheManager(60)
+	require.NoError(t, err)
+	signal := CacheSignal{CacheKeyID: "pubsub-publish", Path: "/pubsub/publish"}
+	require.NoError(t, marshal.Set(ctx, signal.CacheKeyID, &CacheValue{Status: http.StatusOK, Timestamp: time.Now().Unix(), TTL: 60}))
+	conf.indexCacheKey(signal, []string{"pubsub-publish-tag"}, 60)
+
+	subscriber := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer subscriber.Close()
+	pubsub := subscriber.Subscribe(ctx, conf.InvalidationChannel)
+	defer pubsub.Close()
+	_, err = pubsub.Receive(ctx)
+	require.NoError(t, err)
+
+	purged, err := conf.purge(ctx, purgeRequest{Tag: "pubsub-publish-tag"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	message, err := pubsub.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	var msg invalidationMessage
+	require.NoError(t, json.Unmarshal([]byte(message.Payload), &msg))
+	assert.Equal(t, invalidationOrigin, msg.Origin)
+	assert.Equal(t, "pubsub-publish-tag", msg.Tag)
+	// L2 의 인덱스는 지워졌으므로 다른 노드가 L1 에서 지울 캐시 키를 함께 보낸다
+	assert.Equal(t, []string{"pubsub-publish"}, msg.Keys)
+	assert.False(t, server.Exists("pubsub-publish"))
+}
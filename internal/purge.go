@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creasty/defaults"
+	"github.com/mitchellh/hashstructure/v2"
+)
+
+// purgeListenEnv 는 purge API 를 열 주소를 담는 환경 변수다.
+// `127.0.0.1:8100` 처럼 TCP 주소를 쓰거나, `unix:/tmp/sonic-boom.sock` 처럼 유닉스 소켓을 쓴다.
+const purgeListenEnv = "SONIC_BOOM_PURGE_LISTEN"
+
+// purgeTokenEnv 는 purge API 의 공유 비밀을 담는 환경 변수다. 있으면 요청에 `Authorization: Bearer <token>` 이 있어야 한다.
+// 루프백이 아닌 TCP 주소에서는 반드시 있어야 한다.
+const purgeTokenEnv = "SONIC_BOOM_PURGE_TOKEN"
+
+var (
+	purgeListen = os.Getenv(purgeListenEnv)
+	purgeToken  = os.Getenv(purgeTokenEnv)
+
+	// purge API 가 지울 스토어. 요청을 처리한 설정값을 스토어별로 하나씩 기억한다
+	purgeTargets sync.Map // map[uint64]*Config
+)
+
+func purgeEnabled() bool {
+	return purgeListen != ""
+}
+
+// purgeScope 는 같은 스토어와 같은 경로 정규화를 쓰는 설정값을 하나로 묶는다.
+type purgeScope struct {
+	Strategy      string
+	Redis         RedisConfig
+	RedisCluster  RedisClusterConfig
+	RedisSentinel RedisSentinelConfig
+	InMemory      InMemoryConfig
+	Tiered        TieredConfig
+	NormalizePath bool
+	IgnoreURICase bool
+}
+
+// registerPurgeTarget 은 purge API 가 이 설정값의 스토어를 지울 수 있도록 기억한다.
+func (conf *Config) registerPurgeTarget() {
+	if !purgeEnabled() {
+		return
+	}
+
+	hash, err := conf.purgeScopeHash()
+	if err != nil {
+		conf.logger.Error().Err(err).Msg("hashing error")
+		return
+	}
+	if _, ok := purgeTargets.Load(hash); ok {
+		return
+	}
+
+	purgeTargets.LoadOrStore(hash, conf)
+}
+
+// unregisterPurgeTarget 은 치우는 설정값을 purge API 의 대상에서 뺀다.
+func (conf *Config) unregisterPurgeTarget() {
+	purgeTargets.Range(func(hash, target any) bool {
+		purgeTargets.CompareAndDelete(hash, conf)
+		return true
+	})
+}
+
+// purgeScopeHash 는 purgeScope 의 해시다. configRuntime 이 있으면 만들 때 구한 값을 돌려준다.
+func (conf *Config) purgeScopeHash() (uint64, error) {
+	if conf.rt != nil {
+		return conf.rt.purgeHash, conf.rt.purgeHashErr
+	}
+	return conf.hashPurgeScope()
+}
+
+func (conf *Config) hashPurgeScope() (uint64, error) {
+	return hashstructure.Hash(purgeScope{
+		Strategy:      conf.Strategy,
+		Redis:         conf.Redis,
+		RedisCluster:  conf.RedisCluster,
+		RedisSentinel: conf.RedisSentinel,
+		InMemory:      conf.InMemory,
+		Tiered:        conf.Tiered,
+		NormalizePath: conf.NormalizePath,
+		IgnoreURICase: conf.IgnoreURICase,
+	}, hashstructure.FormatV2, nil)
+}
+
+// purgeRequest 는 지울 캐시를 고른다. Soft 를 빼고 하나만 채운다.
+type purgeRequest struct {
+	Key     string
+	Path    string
+	Prefix  string
+	Regex   *regexp.Regexp
+	Service string
+	Route   string
+	Tag     string
+	// Soft 이면 지우지 않고 stale 하게 만든다. 업스트림이 회복하는 동안 stale 한 값으로 응답할 수 있다
+	Soft bool
+}
+
+// purge 는 이 설정값의 스토어에서 req 에 맞는 캐시를 지우고, 지운 캐시 키의 수를 돌려준다.
+// invalidation_channel 이 있으면 다른 Kong 노드에도 알려 L1 에서 지우게 한다.
+func (conf *Config) purge(ctx context.Context, req purgeRequest) (int, error) {
+	if !conf.broadcastsInvalidation() {
+		return conf.purgeLocal(ctx, req)
+	}
+
+	// tiered 전략의 인덱스는 L2 에 있어 지우고 나면 찾을 수 없으므로 다른 노드의 L1 에서 지울 캐시 키를 먼저 찾는다
+	keys, err := conf.l1Keys(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := conf.purgeLocal(ctx, req)
+	if err != nil {
+		return purged, err
+	}
+	return purged, conf.publishInvalidation(ctx, req, keys)
+}
+
+// purgeLocal 은 다른 노드에 알리지 않고 이 노드의 스토어에서만 지운다.
+func (conf *Config) purgeLocal(ctx context.Context, req purgeRequest) (int, error) {
+	if req.Key != "" {
+		return conf.purgeKey(ctx, req.Key, req.Soft)
+	}
+	names, err := conf.purgeIndexNames(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	return conf.purgeIndexes(ctx, req.Soft, names...)
+}
+
+// purgeIndexNames 는 req 에 맞는 인덱스 이름들을 돌려준다.
+func (conf *Config) purgeIndexNames(ctx context.Context, req purgeRequest) ([]string, error) {
+	switch {
+	case req.Path != "":
+		return []string{pathIndexName(conf.cacheKeyPath(req.Path))}, nil
+	case req.Prefix != "" || req.Regex != nil:
+		return conf.pathIndexNames(ctx, req.Prefix, req.Regex)
+	case req.Service != "":
+		return []string{serviceIndexName(req.Service)}, nil
+	case req.Route != "":
+		return []string{routeIndexName(req.Route)}, nil
+	case req.Tag != "":
+		return []string{tagIndexName(req.Tag)}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// purgeKey 는 캐시 키 하나를 지운다. 없던 키이면 0 을 돌려준다.
+func (conf *Config) purgeKey(ctx context.Context, cacheKeyID string, soft bool) (int, error) {
+	cacheManager, marshal, err := conf.newCacheManager(0)
+	if err != nil {
+		return 0, err
+	}
+	if soft {
+		ok, err := softPurgeKey(ctx, marshal, cacheKeyID, time.Now().Unix())
+		if !ok {
+			return 0, err
+		}
+		return 1, nil
+	}
+	if _, err := cacheManager.Get(ctx, cacheKeyID); err != nil {
+		return 0, nil
+	}
+	if err := marshal.Delete(ctx, cacheKeyID); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (conf *Config) purgeIndexes(ctx context.Context, soft bool, names ...string) (int, error) {
+	_, marshal, err := conf.newCacheManager(0)
+	if err != nil {
+		return 0, err
+	}
+	index, err := conf.newKeyIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	purged := 0
+	for _, name := range names {
+		var n int
+		if soft {
+			n, err = softPurgeIndex(ctx, marshal, index, name, now)
+		} else {
+			n, err = purgeIndex(ctx, marshal, index, name)
+		}
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// pathIndexNames 는 prefix 아래에 있거나 re 에 맞는 경로의 인덱스 이름들을 돌려준다.
+// prefix 는 경로의 세그먼트 단위로 맞춘다. `/api/` 는 `/api` 와 `/api/users` 에 맞고 `/apix` 에는 맞지 않는다.
+func (conf *Config) pathIndexNames(ctx context.Context, prefix string, re *regexp.Regexp) ([]string, error) {
+	index, err := conf.newKeyIndex()
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimSuffix(conf.cacheKeyPath(prefix), "/")
+	names, err := index.names(ctx, pathIndexName(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for _, name := range names {
+		path := strings.TrimPrefix(name, pathIndexPrefix)
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		if re == nil || re.MatchString(path) {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
+// parsePurgeRequest 는 `key`, `path`, `prefix`, `regex`, `service`, `route`, `tag` 쿼리 인자 가운데 하나를 읽는다.
+// `soft=true` 이면 soft purge 한다.
+func parsePurgeRequest(r *http.Request) (purgeRequest, error) {
+	query := r.URL.Query()
+
+	var req purgeRequest
+	given := 0
+	for name, field := range map[string]*string{
+		"key":     &req.Key,
+		"path":    &req.Path,
+		"prefix":  &req.Prefix,
+		"service": &req.Service,
+		"route":   &req.Route,
+		"tag":     &req.Tag,
+	} {
+		if v := query.Get(name); v != "" {
+			*field = v
+			given++
+		}
+	}
+	if v := query.Get("regex"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return req, fmt.Errorf("invalid regex: %v", err)
+		}
+		req.Regex = re
+		given++
+	}
+
+	if v := query.Get("soft"); v != "" {
+		soft, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, fmt.Errorf("invalid soft: %v", err)
+		}
+		req.Soft = soft
+	}
+
+	if given != 1 {
+		return req, fmt.Errorf("exactly one of key, path, prefix, regex, service, route and tag is required")
+	}
+	return req, nil
+}
+
+// newPurgeHandler 는 purge API 의 핸들러다.
+//
+//	DELETE /purge?path=/products/42
+//
+// 기억해 둔 모든 스토어에서 지우고 `{"purged": 3}` 처럼 지운 캐시 키의 수로 응답한다.
+func newPurgeHandler(logger *Logger, token string) http.Handler {
+	writeJSON := func(w http.ResponseWriter, status int, body any) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/purge", func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !validPurgeToken(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+			return
+		}
+
+		if r.Method != http.MethodDelete && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "DELETE, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"message": "Method not allowed"})
+			return
+		}
+
+		req, err := parsePurgeRequest(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+
+		purged := 0
+		var errs []string
+		purgeTargets.Range(func(_, value any) bool {
+			n, err := value.(*Config).purge(r.Context(), req)
+			purged += n
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+			return true
+		})
+		if len(errs) > 0 {
+			logger.Error().Strs("errors", errs).Msg("Purge failed")
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"purged": purged, "errors": errs})
+			return
+		}
+		logger.Info().Msgf("%d cache entries are purged: %s", purged, r.URL.RawQuery)
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+	})
+	return mux
+}
+
+// validPurgeToken 은 요청의 `Authorization: Bearer <token>` 이 token 과 같은지 알려준다.
+func validPurgeToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// StartPurgeServer 는 SONIC_BOOM_PURGE_LISTEN 이 있으면 purge API 를 연다. 없으면 아무것도 하지 않는다.
+// 루프백이 아닌 TCP 주소는 SONIC_BOOM_PURGE_TOKEN 이 없으면 열지 않는다.
+func StartPurgeServer() error {
+	if !purgeEnabled() {
+		return nil
+	}
+
+	network, address := "tcp", purgeListen
+	if path, ok := strings.CutPrefix(purgeListen, "unix:"); ok {
+		network, address = "unix", path
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen purge API on %s: %w", purgeListen, err)
+	}
+	// `localhost` 같은 이름도 풀어서 보도록 연 주소로 확인한다
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() && purgeToken == "" {
+		_ = listener.Close()
+		return fmt.Errorf("purge API on non-loopback address %s requires %s", purgeListen, purgeTokenEnv)
+	}
+
+	logConf := &LogConfig{}
+	if err := defaults.Set(logConf); err != nil {
+		return err
+	}
+	logger := NewLogger(logConf)
+	handler := newPurgeHandler(logger, purgeToken)
+	go func() {
+		if err := http.Serve(listener, handler); err != nil {
+			logger.Error().Err(err).Msg("Purge API is stopped")
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/creasty/defaults"
+	lib_store "github.com/eko/gocache/lib/v4/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enablePurgeForTest 는 purge API 가 열린 것처럼 하고, 끝나면 기억한 스토어를 지운다.
+func enablePurgeForTest(t *testing.T) {
+	listen := purgeListen
+	purgeListen = "127.0.0.1:0"
+	t.Cleanup(func() {
+		purgeListen = listen
+		purgeTargets.Range(func(key, _ any) bool {
+			purgeTargets.Delete(key)
+			return true
+		})
+	})
+}
+
+func TestParsePurgeRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    purgeRequest
+		wantErr bool
+	}{
+		{name: "key", query: "key=123", want: purgeRequest{Key: "123"}},
+		{name: "path", query: "path=/products/42", want: purgeRequest{Path: "/products/42"}},
+		{name: "prefix", query: "prefix=/products/", want: purgeRequest{Prefix: "/products/"}},
+		{name: "service", query: "service=s1", want: purgeRequest{Service: "s1"}},
+		{name: "route", query: "route=r1", want: purgeRequest{Route: "r1"}},
+		{name: "tag", query: "tag=product-42", want: purgeRequest{Tag: "product-42"}},
+		{name: "soft", query: "path=/products/42&soft=true", want: purgeRequest{Path: "/products/42", Soft: true}},
+		{name: "soft only", query: "soft=true", wantErr: true},
+		{name: "invalid soft", query: "path=/products/42&soft=maybe", wantErr: true},
+		{name: "none", query: "", wantErr: true},
+		{name: "two", query: "path=/a&route=r1", wantErr: true},
+		{name: "invalid regex", query: "regex=(", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/purge?"+tt.query, nil)
+			got, err := parsePurgeRequest(r)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodDelete, "/purge?regex=^/products/[0-9]%2B$", nil)
+	got, err := parsePurgeRequest(r)
+	require.NoError(t, err)
+	assert.True(t, got.Regex.MatchString("/products/42"))
+}
+
+func TestPurgeHandler(t *testing.T) {
+	enablePurgeForTest(t)
+
+	inMemory := newInMemoryConfigForTest()
+	inMemory.logger = NewLogger(&inMemory.LogConf)
+	defer inMemory.logger.Close()
+	inMemory.registerPurgeTarget()
+
+	redisConf, _ := newRedisConfigForTest(t)
+	redisConf.registerPurgeTarget()
+	// 같은 스토어는 한 번만 기억한다
+	inMemory.registerPurgeTarget()
+	count := 0
+	purgeTargets.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	require.Equal(t, 2, count)
+
+	ctx := context.Background()
+	entries := []CacheSignal{
+		{CacheKeyID: "purge-products-42", Path: "/products/42", Service: "s1", Route: "r1"},
+		{CacheKeyID: "purge-products-43", Path: "/products/43", Service: "s1", Route: "r1"},
+		{CacheKeyID: "purge-products-all", Path: "/products", Service: "s1", Route: "r2"},
+		{CacheKeyID: "purge-orders-1", Path: "/orders/1", Service: "s2", Route: "r3"},
+		{CacheKeyID: "purge-products-archive", Path: "/products-archive", Service: "s3", Route: "r4"},
+	}
+	store := func(t *testing.T) {
+		for _, conf := range []*Config{inMemory, redisConf} {
+			_, marshal, err := conf.newCacheManager(60)
+			require.NoError(t, err)
+			for _, signal := range entries {
+				require.NoError(t, marshal.Set(ctx, signal.CacheKeyID, &CacheValue{Status: http.StatusOK, Timestamp: time.Now().Unix(), TTL: 60}))
+				conf.indexCacheKey(signal, nil, 60)
+			}
+			require.Eventually(t, func() bool {
+				for _, signal := range entries {
+					if getCacheValue(ctx, marshal, signal.CacheKeyID) == nil {
+						return false
+					}
+				}
+				return true
+			}, time.Second, 10*time.Millisecond)
+		}
+	}
+
+	handler := newPurgeHandler(inMemory.logger, "")
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		wantStatus int
+		wantPurged int
+		wantKept   []string
+		// soft purge 한 캐시 키. 나머지 캐시 키는 그대로 남는다
+		wantSoftPurged []string
+	}{
+		{name: "key", method: http.MethodDelete, query: "key=purge-products-42", wantStatus: http.StatusOK, wantPurged: 2, wantKept: []string{"purge-products-43", "purge-products-all", "purge-orders-1", "purge-products-archive"}},
+		{name: "path", method: http.MethodDelete, query: "path=/products/42", wantStatus: http.StatusOK, wantPurged: 2, wantKept: []string{"purge-products-43", "purge-products-all", "purge-orders-1", "purge-products-archive"}},
+		// 접두어는 세그먼트 단위로 맞추므로 `/products-archive` 는 남는다
+		{name: "prefix", method: http.MethodPost, query: "prefix=/products/", wantStatus: http.StatusOK, wantPurged: 6, wantKept: []string{"purge-orders-1", "purge-products-archive"}},
+		{name: "prefix without trailing slash", method: http.MethodPost, query: "prefix=/products", wantStatus: http.StatusOK, wantPurged: 6, wantKept: []string{"purge-orders-1", "purge-products-archive"}},
+		{name: "regex", method: http.MethodDelete, query: "regex=^/products(/[0-9]%2B)?$", wantStatus: http.StatusOK, wantPurged: 6, wantKept: []string{"purge-orders-1", "purge-products-archive"}},
+		{name: "service", method: http.MethodDelete, query: "service=s1", wantStatus: http.StatusOK, wantPurged: 6, wantKept: []string{"purge-orders-1", "purge-products-archive"}},
+		{name: "route", method: http.MethodDelete, query: "route=r1", wantStatus: http.StatusOK, wantPurged: 4, wantKept: []string{"purge-products-all", "purge-orders-1", "purge-products-archive"}},
+		{name: "soft", method: http.MethodDelete, query: "path=/products/42&soft=true", wantStatus: http.StatusOK, wantPurged: 2, wantSoftPurged: []string{"purge-products-42"}},
+		{name: "bad request", method: http.MethodDelete, query: "", wantStatus: http.StatusBadRequest},
+		{name: "method not allowed", method: http.MethodGet, query: "path=/products/42", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store(t)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/purge?"+tt.query, nil))
+			require.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Purged int `json:"purged"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tt.wantPurged, body.Purged)
+
+			for _, conf := range []*Config{inMemory, redisConf} {
+				_, marshal, err := conf.newCacheManager(60)
+				require.NoError(t, err)
+				for _, signal := range entries {
+					value := getCacheValue(ctx, marshal, signal.CacheKeyID)
+					if tt.wantSoftPurged != nil {
+						require.NotNil(t, value, "%s %s", conf.Strategy, signal.CacheKeyID)
+						assert.Equal(t, slices.Contains(tt.wantSoftPurged, signal.CacheKeyID), value.Purged > 0, "%s %s", conf.Strategy, signal.CacheKeyID)
+						continue
+					}
+					assert.Equal(t, slices.Contains(tt.wantKept, signal.CacheKeyID), value != nil, "%s %s", conf.Strategy, signal.CacheKeyID)
+				}
+			}
+		})
+	}
+}
+
+// cache_ttl 이 0 인 캐시는 만료되지 않으므로 인덱스도 남아 태그와 경로로 지울 수 있다
+func TestConfig_purge_CacheTTLZero(t *testing.T) {
+	enablePurgeForTest(t)
+
+	inMemory := newInMemoryConfigForTest()
+	inMemory.logger = NewLogger(&inMemory.LogConf)
+	defer inMemory.logger.Close()
+	redisConf, server := newRedisConfigForTest(t)
+
+	ctx := context.Background()
+	for _, conf := range []*Config{inMemory, redisConf} {
+		t.Run(conf.Strategy, func(t *testing.T) {
+			require.Zero(t, conf.CacheTTL)
+			require.Zero(t, conf.StorageTTL)
+			_, marshal, err := conf.newCacheManager(conf.CacheTTL)
+			require.NoError(t, err)
+
+			tagged := CacheSignal{CacheKeyID: conf.Strategy + "-ttl-zero-tagged", Path: "/ttl-zero/tagged"}
+			pathOnly := CacheSignal{CacheKeyID: conf.Strategy + "-ttl-zero-path", Path: "/ttl-zero/path"}
+			for _, entry := range []struct {
+				signal CacheSignal
+				tags   []string
+			}{
+				{signal: tagged, tags: []string{"ttl-zero-tag"}},
+				{signal: pathOnly},
+			} {
+				value := &CacheValue{Status: http.StatusOK, Timestamp: time.Now().Unix(), TTL: int64(conf.CacheTTL)}
+				require.Zero(t, value.storageTTL())
+				require.NoError(t, marshal.Set(ctx, entry.signal.CacheKeyID, value, lib_store.WithExpiration(0)))
+				conf.indexCacheKey(entry.signal, entry.tags, value.storageTTL())
+			}
+			require.Eventually(t, func() bool {
+				return getCacheValue(ctx, marshal, pathOnly.CacheKeyID) != nil
+			}, time.Second, 10*time.Millisecond)
+			if conf.Strategy == "redis" {
+				server.FastForward(time.Hour)
+			}
+
+			purged, err := conf.purge(ctx, purgeRequest{Tag: "ttl-zero-tag"})
+			require.NoError(t, err)
+			assert.Equal(t, 1, purged)
+			assert.Nil(t, getCacheValue(ctx, marshal, tagged.CacheKeyID))
+
+			purged, err = conf.purge(ctx, purgeRequest{Path: "/ttl-zero/path"})
+			require.NoError(t, err)
+			assert.Equal(t, 1, purged)
+			assert.Nil(t, getCacheValue(ctx, marshal, pathOnly.CacheKeyID))
+		})
+	}
+}
+
+func TestNewPurgeHandler_Token(t *testing.T) {
+	logConf := &LogConfig{}
+	require.NoError(t, defaults.Set(logConf))
+	logger := NewLogger(logConf)
+	defer logger.Close()
+
+	handler := newPurgeHandler(logger, "secret")
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+		{name: "wrong", authorization: "Bearer wrong", wantStatus: http.StatusUnauthorized},
+		{name: "not bearer", authorization: "secret", wantStatus: http.StatusUnauthorized},
+		// 인증을 통과하면 쿼리 인자를 본다
+		{name: "valid", authorization: "Bearer secret", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodDelete, "/purge", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestStartPurgeServer_NonLoopback(t *testing.T) {
+	listen, token := purgeListen, purgeToken
+	t.Cleanup(func() { purgeListen, purgeToken = listen, token })
+
+	// 인증 없이 밖으로 열지 않는다
+	purgeListen, purgeToken = "0.0.0.0:0", ""
+	assert.ErrorContains(t, StartPurgeServer(), purgeTokenEnv)
+
+	// 루프백 주소는 이름으로 줘도 연다
+	purgeListen = "localhost:0"
+	assert.NoError(t, StartPurgeServer())
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisClientIdleTimeout 동안 쓰지 않은 클라이언트는 닫는다
+	redisClientIdleTimeout = 5 * time.Minute
+	// 같은 Redis 를 가리키는 새 설정값이 생기면, 이전 설정값의 클라이언트는 이 기간 동안 쓰지 않았을 때 닫는다
+	redisClientReplacedGrace = time.Minute
+	// 닫을 클라이언트를 찾는 주기
+	redisClientSweepInterval = 30 * time.Second
+)
+
+// Redis 설정값별로 클라이언트를 재사용하기 위한 맵. ristrettoClients 와 같이 설정값별로 나눈다
+var redisClients = &redisClientRegistry{entries: map[uint64]*redisClientEntry{}}
+
+type redisClientEntry struct {
+	client redis.UniversalClient
+	// target 은 접속할 Redis 다. 같은 target 의 새 설정값이 생기면 replaced 가 된다
+	target   string
+	lastUsed time.Time
+	replaced bool
+}
+
+// redisClientRegistry 는 Redis 클라이언트를 설정값별로 하나씩 만들어 요청 사이에 함께 쓴다.
+// 오래 쓰지 않았거나 설정값이 바뀐 클라이언트는 sweep 이 닫는다.
+type redisClientRegistry struct {
+	mu        sync.Mutex
+	entries   map[uint64]*redisClientEntry
+	sweepOnce sync.Once
+}
+
+// redisClientKey 는 클라이언트를 나눌 설정값의 해시와 접속할 Redis 를 돌려준다.
+func (conf *Config) redisClientKey() (uint64, string, error) {
+	var scope any
+	var target string
+	switch conf.redisStrategy() {
+	case "redis":
+		scope = conf.Redis
+		target = "redis://" + conf.Redis.Host + ":" + strconv.Itoa(conf.Redis.Port) + "/" + strconv.Itoa(conf.Redis.DBNumber)
+	case "redis-cluster":
+		scope = conf.RedisCluster
+		addrs := slices.Clone(conf.RedisCluster.Addrs)
+		slices.Sort(addrs)
+		target = "redis-cluster://" + strings.Join(addrs, ",")
+	case "redis-sentinel":
+		scope = conf.RedisSentinel
+		addrs := slices.Clone(conf.RedisSentinel.SentinelAddrs)
+		slices.Sort(addrs)
+		target = "redis-sentinel://" + conf.RedisSentinel.MasterName + "@" + strings.Join(addrs, ",") + "/" + strconv.Itoa(conf.RedisSentinel.DBNumber)
+	default:
+		return 0, "", fmt.Errorf("strategy %s does not use redis", conf.Strategy)
+	}
+
+	hash, err := hashstructure.Hash(struct {
+		Strategy string
+		Scope    any
+	}{conf.redisStrategy(), scope}, hashstructure.FormatV2, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	return hash, target, nil
+}
+
+// acquire 는 key 의 클라이언트를 돌려준다. 없으면 build 로 만든다.
+func (r *redisClientRegistry) acquire(key uint64, target string, build func() (redis.UniversalClient, error)) (redis.UniversalClient, error) {
+	r.sweepOnce.Do(func() {
+		go func() {
+			for range time.Tick(redisClientSweepInterval) {
+				r.sweep(time.Now())
+			}
+		}()
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := r.entries[key]; ok {
+		entry.lastUsed = now
+		// 이전 설정값을 쓰는 플러그인 인스턴스가 아직 남아 있다
+		entry.replaced = false
+		return entry.client, nil
+	}
+
+	client, err := build()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range r.entries {
+		if entry.target == target {
+			entry.replaced = true
+		}
+	}
+	r.entries[key] = &redisClientEntry{client: client, target: target, lastUsed: now}
+	return client, nil
+}
+
+// sweep 은 오래 쓰지 않았거나 설정값이 바뀐 클라이언트를 닫는다.
+// 클라이언트를 얻은 요청은 곧바로 쓰므로, 유예 기간 동안 쓰지 않은 클라이언트는 닫아도 된다.
+func (r *redisClientRegistry) sweep(now time.Time) {
+	var closing []redis.UniversalClient
+
+	r.mu.Lock()
+	for key, entry := range r.entries {
+		idle := now.Sub(entry.lastUsed)
+		if idle > redisClientIdleTimeout || entry.replaced && idle > redisClientReplacedGrace {
+			delete(r.entries, key)
+			closing = append(closing, entry.client)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, client := range closing {
+		_ = client.Close()
+	}
+}
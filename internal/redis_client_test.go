@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_newRedisClient(t *testing.T) {
+	cfg, _ := newRedisConfigForTest(t)
+
+	client, err := cfg.newRedisClient()
+	require.NoError(t, err)
+	again, err := cfg.newRedisClient()
+	require.NoError(t, err)
+	assert.Same(t, client, again)
+	require.NoError(t, client.Ping(context.Background()).Err())
+
+	other := *cfg
+	other.Redis.PoolSize = cfg.Redis.PoolSize + 1
+	otherClient, err := other.newRedisClient()
+	require.NoError(t, err)
+	assert.NotSame(t, client, otherClient)
+
+	_, err = newInMemoryConfigForTest().newRedisClient()
+	assert.Error(t, err)
+}
+
+func TestConfig_redisClientKey(t *testing.T) {
+	cluster := configDefault()
+	cluster.Strategy = "redis-cluster"
+	cluster.RedisCluster.Addrs = []string{"b:6379", "a:6379"}
+	key, target, err := cluster.redisClientKey()
+	require.NoError(t, err)
+	assert.Equal(t, "redis-cluster://a:6379,b:6379", target)
+
+	// 비밀번호가 바뀌면 다른 클라이언트를 쓴다
+	cluster.RedisCluster.Password = "changed"
+	changed, sameTarget, err := cluster.redisClientKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, changed)
+	assert.Equal(t, target, sameTarget)
+
+	single := configDefault()
+	single.Redis.Host = "localhost"
+	_, target, err = single.redisClientKey()
+	require.NoError(t, err)
+	assert.Equal(t, "redis://localhost:6379/0", target)
+
+	sentinel := configDefault()
+	sentinel.Strategy = "redis-sentinel"
+	sentinel.RedisSentinel.MasterName = "mymaster"
+	sentinel.RedisSentinel.SentinelAddrs = []string{"s2:26379", "s1:26379"}
+	sentinel.RedisSentinel.DBNumber = 2
+	_, target, err = sentinel.redisClientKey()
+	require.NoError(t, err)
+	assert.Equal(t, "redis-sentinel://mymaster@s1:26379,s2:26379/2", target)
+}
+
+func TestConfig_buildRedisClient_Sentinel(t *testing.T) {
+	conf := configDefault()
+	conf.Strategy = "redis-sentinel"
+	conf.RedisSentinel.MasterName = "mymaster"
+	conf.RedisSentinel.SentinelAddrs = []string{"localhost:26379"}
+	conf.RedisSentinel.DBNumber = 3
+	conf.RedisSentinel.PoolSize = 7
+
+	client, err := conf.buildRedisClient()
+	require.NoError(t, err)
+	defer client.Close()
+
+	// go-redis 의 failover 클라이언트는 Sentinel 이 알려 준 마스터에 접속하는 *redis.Client 다
+	failover, ok := client.(*redis.Client)
+	require.True(t, ok)
+	assert.Equal(t, 3, failover.Options().DB)
+	assert.Equal(t, 7, failover.Options().PoolSize)
+}
+
+func TestRedisClientRegistry(t *testing.T) {
+	r := &redisClientRegistry{entries: map[uint64]*redisClientEntry{}}
+	built := 0
+	build := func() (redis.UniversalClient, error) {
+		built++
+		return redis.NewClient(&redis.Options{Addr: "localhost:0"}), nil
+	}
+
+	first, err := r.acquire(1, "redis://localhost:6379/0", build)
+	require.NoError(t, err)
+	again, err := r.acquire(1, "redis://localhost:6379/0", build)
+	require.NoError(t, err)
+	assert.Same(t, first, again)
+	assert.Equal(t, 1, built)
+
+	// 같은 Redis 를 가리키는 새 설정값이 생기면 이전 클라이언트는 유예 기간 뒤에 닫는다
+	_, err = r.acquire(2, "redis://localhost:6379/0", build)
+	require.NoError(t, err)
+	_, err = r.acquire(3, "redis://localhost:6380/0", build)
+	require.NoError(t, err)
+	assert.Equal(t, 3, built)
+	assert.True(t, r.entries[1].replaced)
+	assert.False(t, r.entries[2].replaced)
+
+	now := time.Now()
+	r.sweep(now)
+	assert.Len(t, r.entries, 3)
+
+	r.sweep(now.Add(redisClientReplacedGrace + time.Second))
+	assert.NotContains(t, r.entries, uint64(1))
+	assert.Contains(t, r.entries, uint64(2))
+	assert.ErrorIs(t, first.Ping(context.Background()).Err(), redis.ErrClosed)
+
+	// 다시 쓰면 새로 만든다
+	reopened, err := r.acquire(1, "redis://localhost:6379/0", build)
+	require.NoError(t, err)
+	assert.NotSame(t, first, reopened)
+	assert.True(t, r.entries[2].replaced)
+
+	// 이전 설정값을 다시 쓰면 닫지 않는다
+	_, err = r.acquire(2, "redis://localhost:6379/0", build)
+	require.NoError(t, err)
+	assert.False(t, r.entries[2].replaced)
+
+	// 오래 쓰지 않은 클라이언트는 모두 닫는다
+	r.sweep(time.Now().Add(redisClientIdleTimeout + time.Second))
+	assert.Empty(t, r.entries)
+}
@@ -47,3 +47,31 @@ type RedisClusterConfig struct {
 	PoolTimeout  int `json:"pool_timeout" validate:"gte=-1" default:"5"`
 	IdleTimeout  int `json:"idle_timeout" validate:"gte=-1" default:"1"`
 }
+
+// RedisSentinelConfig 는 Sentinel 이 관리하는 Redis 를 위한 설정입니다.
+// Sentinel 에게 MasterName 의 마스터 주소를 물어 접속하고, 장애 조치로 마스터가 바뀌면 새 마스터로 다시 접속합니다.
+type RedisSentinelConfig struct {
+	MasterName       string   `json:"master_name"`
+	SentinelAddrs    []string `json:"sentinel_addrs"`
+	SentinelUsername string   `json:"sentinel_username"`
+	SentinelPassword string   `json:"sentinel_password"`
+	DBNumber         int      `json:"db_number" validate:"gte=0" default:"0"`
+	Username         string   `json:"username"`
+	Password         string   `json:"password"`
+
+	// Connection settings
+	PoolSize int `json:"pool_size" validate:"gt=0" default:"10"`
+	// -1 disables retries.
+	MaxRetries int `json:"max_retries" validate:"gte=-1" default:"3"`
+	// -1 disables backoff.
+	MinRetryBackoffMs int `json:"min_retry_backoff_ms" validate:"gte=-1" default:"8"`
+	// -1 disables backoff.
+	MaxRetryBackoffMs int `json:"max_retry_backoff_ms" validate:"gte=-1" default:"512"`
+
+	// Timeouts
+	DialTimeout  int `json:"dial_timeout" validate:"gte=-1" default:"5"`
+	ReadTimeout  int `json:"read_timeout" validate:"gte=-1" default:"3"`
+	WriteTimeout int `json:"write_timeout" validate:"gte=-1" default:"3"`
+	PoolTimeout  int `json:"pool_timeout" validate:"gte=-1" default:"5"`
+	IdleTimeout  int `json:"idle_timeout" validate:"gte=-1" default:"1"`
+}
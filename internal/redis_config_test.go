@@ -161,3 +161,106 @@ func TestRedisClusterConfig_Validation(t *testing.T) {
 		})
 	}
 }
+
+func TestRedisSentinelConfig_Validation(t *testing.T) {
+	validate := validator.New()
+
+	tests := []struct {
+		name    string
+		config  RedisSentinelConfig
+		wantErr bool
+	}{
+		{
+			name: "valid config with minimum values",
+			config: RedisSentinelConfig{
+				MasterName:    "mymaster",
+				SentinelAddrs: []string{"localhost:26379"},
+				PoolSize:      1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with all fields",
+			config: RedisSentinelConfig{
+				MasterName:        "mymaster",
+				SentinelAddrs:     []string{"sentinel1:26379", "sentinel2:26379"},
+				SentinelUsername:  "sentinel-user",
+				SentinelPassword:  "sentinel-pass",
+				DBNumber:          1,
+				Username:          "user",
+				Password:          "pass",
+				PoolSize:          10,
+				MaxRetries:        3,
+				MinRetryBackoffMs: 8,
+				MaxRetryBackoffMs: 512,
+				DialTimeout:       5,
+				ReadTimeout:       3,
+				WriteTimeout:      3,
+				PoolTimeout:       5,
+				IdleTimeout:       1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - negative db number",
+			config: RedisSentinelConfig{
+				MasterName:    "mymaster",
+				SentinelAddrs: []string{"localhost:26379"},
+				DBNumber:      -1,
+				PoolSize:      1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - pool size zero",
+			config: RedisSentinelConfig{
+				MasterName:    "mymaster",
+				SentinelAddrs: []string{"localhost:26379"},
+				PoolSize:      0,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate.Struct(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfig_checkConfig_RedisSentinel(t *testing.T) {
+	tests := []struct {
+		name          string
+		strategy      string
+		masterName    string
+		sentinelAddrs []string
+		wantErr       bool
+	}{
+		{name: "valid", strategy: "redis-sentinel", masterName: "mymaster", sentinelAddrs: []string{"localhost:26379"}},
+		{name: "missing master name", strategy: "redis-sentinel", sentinelAddrs: []string{"localhost:26379"}, wantErr: true},
+		{name: "missing sentinel addrs", strategy: "redis-sentinel", masterName: "mymaster", wantErr: true},
+		{name: "tiered over redis-sentinel", strategy: "tiered", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := configDefault()
+			conf.Strategy = tt.strategy
+			conf.Tiered.L2 = "redis-sentinel"
+			conf.RedisSentinel.MasterName = tt.masterName
+			conf.RedisSentinel.SentinelAddrs = tt.sentinelAddrs
+			err := conf.checkConfig()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kong/go-pdk"
+)
+
+// revalidateHeader 는 백그라운드 갱신 요청임을 표시하는 헤더다.
+// 값은 프로세스마다 임의로 만든 토큰이라 클라이언트가 흉내낼 수 없다.
+const revalidateHeader = "X-Sonic-Boom-Revalidate"
+
+const revalidateTimeout = 30 * time.Second
+
+var (
+	revalidateToken = newRandomToken()
+
+	// 갱신 중인 캐시 키. 같은 키에 대해 백그라운드 요청은 하나만 보낸다.
+	revalidating sync.Map // map[string]struct{}
+
+	// Kong 자신에게 보내는 요청이라 인증서는 검증하지 않는다
+	revalidateClient = &http.Client{
+		Timeout: revalidateTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+)
+
+// newRandomToken 은 추측할 수 없는 16바이트 hex 토큰을 만든다.
+func newRandomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// 백그라운드 요청에 옮기지 않을 요청 헤더
+var revalidateSkipHeaders = map[string]bool{
+	"content-length":      true,
+	"if-match":            true,
+	"if-none-match":       true,
+	"if-modified-since":   true,
+	"if-unmodified-since": true,
+	"if-range":            true,
+	"cache-control":       true,
+	"pragma":              true,
+}
+
+// revalidateRequest 는 클라이언트 요청을 그대로 Kong 에 다시 보내기 위한 정보다.
+// Kong 을 거치므로 라우팅, 로드밸런싱, 인증 플러그인이 그대로 적용되고, 응답은 Response 에서 저장된다.
+type revalidateRequest struct {
+	URL     string
+	Method  string
+	Host    string
+	Headers map[string][]string
+	Body    []byte
+}
+
+func (conf *Config) newRevalidateRequest(kong *pdk.PDK, body []byte) (*revalidateRequest, error) {
+	method, err := kong.Request.GetMethod()
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := kong.Request.GetScheme()
+	if err != nil {
+		return nil, err
+	}
+	serverAddr, err := kong.Nginx.GetVar("server_addr")
+	if err != nil {
+		return nil, err
+	}
+	serverPort, err := kong.Nginx.GetVar("server_port")
+	if err != nil {
+		return nil, err
+	}
+	pathWithQuery, err := kong.Request.GetPathWithQuery()
+	if err != nil {
+		return nil, err
+	}
+	headers, err := kong.Request.GetHeaders(1000)
+	if err != nil {
+		return nil, err
+	}
+
+	host := ""
+	forward := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		name := strings.ToLower(k)
+		if name == "host" {
+			if len(v) > 0 {
+				host = v[0]
+			}
+			continue
+		}
+		if hopByHopHeaders[name] || revalidateSkipHeaders[name] {
+			continue
+		}
+		forward[k] = v
+	}
+
+	return &revalidateRequest{
+		URL:     scheme + "://" + net.JoinHostPort(serverAddr, serverPort) + pathWithQuery,
+		Method:  method,
+		Host:    host,
+		Headers: forward,
+		Body:    body,
+	}, nil
+}
+
+// isRevalidateRequest 는 이 플러그인이 보낸 백그라운드 갱신 요청인지 확인한다.
+func (conf *Config) isRevalidateRequest(kong *pdk.PDK) bool {
+	v, err := kong.Request.GetHeader(revalidateHeader)
+	if err != nil || v == "" {
+		return false
+	}
+	return v == revalidateToken
+}
+
+// revalidateInBackground 는 캐시 키에 대한 갱신 요청을 백그라운드로 보낸다.
+// 이미 갱신 중이면 아무 것도 하지 않고 false 를 돌려준다.
+// Redis 를 함께 쓰는 전략은 collapse 의 잠금으로 다른 Kong 노드가 갱신 중인지도 확인한다.
+func (conf *Config) revalidateInBackground(cacheKeyID string, req *revalidateRequest) bool {
+	if _, loaded := revalidating.LoadOrStore(cacheKeyID, struct{}{}); loaded {
+		return false
+	}
+
+	logger := conf.logger
+	var lock fillLock
+	token := ""
+	if conf.redisStrategy() != "" {
+		var err error
+		if lock, err = conf.newFillLock(); err == nil {
+			token, err = lock.acquire(context.Background(), cacheKeyID, revalidateTimeout)
+		}
+		if err != nil {
+			// 잠그지 못해도 이 노드에서는 하나만 보낸다
+			logger.Warn().Err(err).Msgf("Failed to acquire fill lock for revalidating cache key '%s'", cacheKeyID)
+		} else if token == "" {
+			revalidating.Delete(cacheKeyID)
+			return false
+		}
+	}
+
+	go func() {
+		defer revalidating.Delete(cacheKeyID)
+		if token != "" {
+			defer func() {
+				if err := lock.release(context.Background(), cacheKeyID, token, nil); err != nil {
+					logger.Error().Err(err).Msgf("Failed to release fill lock for cache key '%s'", cacheKeyID)
+				}
+			}()
+		}
+
+		status, err := req.do()
+		if err != nil {
+			logger.Warn().Err(err).Msgf("Revalidating cache key '%s' has failed", cacheKeyID)
+			return
+		}
+		if status != "Revalidated" {
+			logger.Warn().Msgf("Revalidating cache key '%s' has not been stored: %s", cacheKeyID, status)
+			return
+		}
+		logger.Debug().Msgf("Cache key '%s' is revalidated", cacheKeyID)
+	}()
+	return true
+}
+
+// do 는 요청을 보내고 응답의 X-Cache-Status 를 돌려준다.
+func (r *revalidateRequest) do() (string, error) {
+	httpReq, err := http.NewRequest(r.Method, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return "", err
+	}
+	for k, v := range r.Headers {
+		for _, vv := range v {
+			httpReq.Header.Add(k, vv)
+		}
+	}
+	if r.Host != "" {
+		httpReq.Host = r.Host
+	}
+	httpReq.Header.Set(revalidateHeader, revalidateToken)
+
+	resp, err := revalidateClient.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.Header.Get("X-Cache-Status"), nil
+}
+
+// revalidateEnabled 는 stale-while-revalidate 가 쓰일 수 있는지 알려준다.
+// cache_control 모드에서는 응답의 stale-while-revalidate 디렉티브로도 켜진다.
+func (conf *Config) revalidateEnabled() bool {
+	return conf.StaleWhileRevalidate > 0 || conf.CacheControl
+}
+
+// revalidate 는 stale 한 값을 응답하는 동안 백그라운드 갱신을 시작하고, 응답할 X-Cache-Status 를 돌려준다.
+//
+//   - Stale: 이 요청이 백그라운드 갱신을 시작했다
+//   - Updating: 이미 갱신 중이다
+func (conf *Config) revalidate(kong *pdk.PDK, cacheKeyID string, body []byte) string {
+	if _, ok := revalidating.Load(cacheKeyID); ok {
+		return "Updating"
+	}
+
+	req, err := conf.newRevalidateRequest(kong, body)
+	if err != nil {
+		conf.logger.Error().Err(err).Msg("Failed to create revalidate request")
+		return "Stale"
+	}
+
+	if !conf.revalidateInBackground(cacheKeyID, req) {
+		return "Updating"
+	}
+	return "Stale"
+}
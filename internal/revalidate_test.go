@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newRevalidateRequest(t *testing.T) {
+	headers, err := bridge.WrapHeaders(map[string][]string{
+		"host":          {"example.com"},
+		"authorization": {"Basic a29yZWFpbnYwMjI4"},
+		"if-none-match": {`"abc"`},
+		"connection":    {"keep-alive"},
+	})
+	require.NoError(t, err)
+
+	kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+		{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+		{Method: "kong.request.get_scheme", Ret: bridge.WrapString("http")},
+		{Method: "kong.nginx.get_var", Args: bridge.WrapString("server_addr"), Ret: bridge.WrapString("::1")},
+		{Method: "kong.nginx.get_var", Args: bridge.WrapString("server_port"), Ret: bridge.WrapString("8000")},
+		{Method: "kong.request.get_path_with_query", Ret: bridge.WrapString("/api/users?page=2")},
+		{Method: "kong.request.get_headers", Ret: headers},
+	})
+
+	conf := configDefault()
+	got, err := conf.newRevalidateRequest(kong, []byte("body"))
+	require.NoError(t, err)
+	recorder.assertConsumed()
+
+	assert.Equal(t, &revalidateRequest{
+		URL:    "http://[::1]:8000/api/users?page=2",
+		Method: "GET",
+		Host:   "example.com",
+		Headers: map[string][]string{
+			"authorization": {"Basic a29yZWFpbnYwMjI4"},
+		},
+		Body: []byte("body"),
+	}, got)
+}
+
+func Test_revalidateRequest_do(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, revalidateToken, r.Header.Get(revalidateHeader))
+		assert.Equal(t, "example.com", r.Host)
+		assert.Equal(t, "1", r.Header.Get("X-Test"))
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "body", string(body))
+
+		w.Header().Set("X-Cache-Status", "Revalidated")
+	}))
+	defer server.Close()
+
+	req := &revalidateRequest{
+		URL:     server.URL + "/api/users",
+		Method:  http.MethodPost,
+		Host:    "example.com",
+		Headers: map[string][]string{"X-Test": {"1"}},
+		Body:    []byte("body"),
+	}
+	status, err := req.do()
+	require.NoError(t, err)
+	assert.Equal(t, "Revalidated", status)
+}
+
+func TestConfig_revalidateInBackground(t *testing.T) {
+	release := make(chan struct{})
+	requested := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		w.Header().Set("X-Cache-Status", "Revalidated")
+	}))
+	defer server.Close()
+
+	conf := configDefault()
+	conf.logger = NewLogger(&conf.LogConf)
+	t.Cleanup(conf.logger.Close)
+	req := &revalidateRequest{URL: server.URL, Method: http.MethodGet}
+
+	// 같은 키는 갱신이 끝날 때까지 한 번만 요청한다
+	assert.True(t, conf.revalidateInBackground("revalidate-key", req))
+	assert.False(t, conf.revalidateInBackground("revalidate-key", req))
+
+	<-requested
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		_, ok := revalidating.Load("revalidate-key")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, requested, 0)
+}
+
+func TestConfig_revalidateInBackground_Redis(t *testing.T) {
+	requested := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		w.Header().Set("X-Cache-Status", "Revalidated")
+	}))
+	defer server.Close()
+
+	conf, redisServer := newRedisConfigForTest(t)
+	req := &revalidateRequest{URL: server.URL, Method: http.MethodGet}
+	cacheKeyID := "revalidate-redis-key"
+
+	// 다른 노드가 갱신 중이면 보내지 않는다
+	lock, err := conf.newFillLock()
+	require.NoError(t, err)
+	token, err := lock.acquire(context.Background(), cacheKeyID, time.Second)
+	require.NoError(t, err)
+	assert.False(t, conf.revalidateInBackground(cacheKeyID, req))
+	_, ok := revalidating.Load(cacheKeyID)
+	assert.False(t, ok)
+
+	// 갱신이 끝나면 잠금을 푼다
+	require.NoError(t, lock.release(context.Background(), cacheKeyID, token, nil))
+	assert.True(t, conf.revalidateInBackground(cacheKeyID, req))
+	<-requested
+	assert.Eventually(t, func() bool {
+		_, ok := revalidating.Load(cacheKeyID)
+		return !ok && !redisServer.Exists(collapseLockPrefix+cacheKeyID)
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, requested, 0)
+}
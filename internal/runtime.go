@@ -0,0 +1,278 @@
+package internal
+
+import (
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eko/gocache/lib/v4/cache"
+	"github.com/eko/gocache/lib/v4/marshaler"
+	"github.com/go-playground/validator/v10"
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/umisama/go-regexpcache"
+	validator_v9 "gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	// 설정값별로 캐시하는 cache manager 의 최대 수. cache_control 모드에서는 ttl 이 응답마다 다를 수 있다
+	maxRuntimeCacheManagers = 64
+	// configRuntimeIdleTimeout 동안 쓰지 않은 configRuntime 은 치우고 logger 를 닫는다
+	configRuntimeIdleTimeout = 10 * time.Minute
+	// 치울 configRuntime 을 찾는 주기
+	configRuntimeSweepInterval = time.Minute
+)
+
+var configRuntimes = &configRuntimeRegistry{
+	byHash: map[uint64]*configRuntime{},
+	byConf: map[*Config]*configRuntime{},
+}
+
+// configRuntimeRegistry 는 configRuntime 을 설정값의 해시별로 하나씩 기억한다.
+// Kong 에서 설정을 고치면 새 플러그인 인스턴스가 생기므로, 오래 쓰지 않은 configRuntime 은 sweep 이 치운다.
+type configRuntimeRegistry struct {
+	mu     sync.RWMutex
+	byHash map[uint64]*configRuntime
+	// byConf 는 go-pdk 가 플러그인 인스턴스마다 한 번 만드는 Config 로 찾는다. 단계마다 설정값을 해시하지 않는다
+	byConf    map[*Config]*configRuntime
+	sweepOnce sync.Once
+}
+
+// configRuntime 은 설정값별로 한 번만 만들어 요청 사이에 함께 쓰는 상태다.
+// go-pdk 는 플러그인 인스턴스의 Config 하나를 여러 요청에서 함께 쓰므로, 요청마다 Init 하고 Close 하는 대신
+// Init 한 사본과 logger, validator, 정규식, cache manager 를 여기에 둔다.
+type configRuntime struct {
+	// conf 는 Init 한 사본이다. 요청에서 고치지 않는다
+	conf     *Config
+	validate *validator.Validate
+	regexps  map[string]*regexp.Regexp
+
+	// validateErr 는 설정값을 검증한 결과다
+	validateErr error
+	// checkOnce 는 디버그 모드에서 checkConfig 를 한 번만 부르도록 한다
+	checkOnce sync.Once
+	checkErr  error
+
+	redisKey    uint64
+	redisTarget string
+	redisErr    error
+
+	// purgeHash 는 purge API 와 invalidation_channel 이 스토어를 가려내는 purgeScope 의 해시다
+	purgeHash    uint64
+	purgeHashErr error
+
+	// invalidationTarget 은 invalidation_channel 을 구독할 Redis 를 가리킨다
+	invalidationTarget string
+	invalidationErr    error
+	// subscribed 는 invalidation_channel 의 구독에 이 설정값을 더했는지 알려준다
+	subscribed atomic.Bool
+	// varyIndexed 는 이 설정값으로 Vary 헤더 이름을 기록한 적이 있는지 알려준다. 없으면 요청마다 기록을 찾지 않는다
+	varyIndexed atomic.Bool
+
+	managers     sync.Map // map[int]*runtimeCacheManager
+	managerCount atomic.Int32
+
+	// lastUsed 는 마지막으로 쓴 시각(UnixNano)이다
+	lastUsed atomic.Int64
+}
+
+type runtimeCacheManager struct {
+	// client 는 만들 때 쓴 Redis 클라이언트다. redisClients 가 닫고 새로 만들었다면 cache manager 도 새로 만든다
+	client  redis.UniversalClient
+	manager cache.CacheInterface[any]
+	marshal *marshaler.Marshaler
+}
+
+// initialized 는 이 설정값의 configRuntime 이 가진, Init 한 사본을 돌려준다. 처음이면 만든다.
+// go-pdk 는 플러그인 인스턴스의 Config 를 고치지 않으므로, 한 번 찾은 Config 는 해시하지 않고 찾는다.
+func (conf *Config) initialized() *Config {
+	if conf.rt != nil {
+		return conf
+	}
+	if rt := configRuntimes.lookup(conf); rt != nil {
+		return rt.conf
+	}
+
+	hash, err := hashstructure.Hash(conf, hashstructure.FormatV2, nil)
+	if err != nil {
+		// 해시할 수 없으면 캐시하지 않는다
+		return newConfigRuntime(conf).conf
+	}
+	return configRuntimes.acquire(conf, hash).conf
+}
+
+func (r *configRuntimeRegistry) lookup(conf *Config) *configRuntime {
+	r.mu.RLock()
+	rt := r.byConf[conf]
+	r.mu.RUnlock()
+	if rt != nil {
+		rt.lastUsed.Store(time.Now().UnixNano())
+	}
+	return rt
+}
+
+// acquire 는 hash 의 configRuntime 을 돌려준다. 없으면 만든다.
+func (r *configRuntimeRegistry) acquire(conf *Config, hash uint64) *configRuntime {
+	r.sweepOnce.Do(func() {
+		go func() {
+			for range time.Tick(configRuntimeSweepInterval) {
+				r.sweep(time.Now())
+			}
+		}()
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rt, ok := r.byHash[hash]
+	if !ok {
+		rt = newConfigRuntime(conf)
+		r.byHash[hash] = rt
+	}
+	r.byConf[conf] = rt
+	rt.lastUsed.Store(time.Now().UnixNano())
+	return rt
+}
+
+// sweep 은 오래 쓰지 않은 configRuntime 을 치우고 logger 를 닫는다.
+// 치운 설정값은 purge API 와 invalidation_channel 의 대상에서 빼고, 같은 스토어를 쓰는 남은 설정값으로 바꾼다.
+func (r *configRuntimeRegistry) sweep(now time.Time) {
+	var closing, live []*configRuntime
+
+	r.mu.Lock()
+	for hash, rt := range r.byHash {
+		if now.Sub(time.Unix(0, rt.lastUsed.Load())) > configRuntimeIdleTimeout {
+			delete(r.byHash, hash)
+			closing = append(closing, rt)
+		} else {
+			live = append(live, rt)
+		}
+	}
+	for conf, rt := range r.byConf {
+		if slices.Contains(closing, rt) {
+			delete(r.byConf, conf)
+		}
+	}
+	r.mu.Unlock()
+
+	if len(closing) == 0 {
+		return
+	}
+	for _, rt := range closing {
+		rt.conf.unregisterPurgeTarget()
+		rt.conf.unsubscribeInvalidation()
+	}
+	for _, rt := range live {
+		rt.conf.registerPurgeTarget()
+		// 구독을 끝낸 채널이 있을 수 있으므로 다시 더한다
+		rt.subscribed.Store(false)
+		rt.conf.subscribeInvalidation()
+	}
+	for _, rt := range closing {
+		_ = rt.conf.Close()
+	}
+}
+
+func newConfigRuntime(conf *Config) *configRuntime {
+	c := *conf
+	// Init 이 Filter 를 고치므로 원본과 나눈다
+	c.Filters = slices.Clone(conf.Filters)
+	c.Init()
+
+	rt := &configRuntime{
+		conf:     &c,
+		validate: validator.New(),
+		regexps:  map[string]*regexp.Regexp{},
+	}
+	for _, filter := range c.Filters {
+		for _, rule := range filter.Rules {
+			// 잘못된 정규식은 예전처럼 요청에서 regexpcache.MustCompile 이 알린다
+			if r, err := regexp.Compile(rule.Regexp); err == nil {
+				rt.regexps[rule.Regexp] = r
+			}
+		}
+	}
+	rt.validateErr = validator_v9.New().Struct(&c)
+	if c.redisStrategy() != "" {
+		rt.redisKey, rt.redisTarget, rt.redisErr = c.redisClientKey()
+	}
+	rt.purgeHash, rt.purgeHashErr = c.hashPurgeScope()
+	if c.broadcastsInvalidation() {
+		_, rt.invalidationTarget, rt.invalidationErr = c.invalidationRedisConfig().redisClientKey()
+	}
+
+	c.rt = rt
+	return rt
+}
+
+// compiledRegexp 는 pattern 을 컴파일한 정규식이다. configRuntime 에 없으면 regexpcache 로 컴파일한다.
+func (conf *Config) compiledRegexp(pattern string) *regexp.Regexp {
+	if conf.rt != nil {
+		if r, ok := conf.rt.regexps[pattern]; ok {
+			return r
+		}
+	}
+	return regexpcache.MustCompile(pattern)
+}
+
+func (conf *Config) validator() *validator.Validate {
+	if conf.rt != nil {
+		return conf.rt.validate
+	}
+	return validator.New()
+}
+
+// validateConfig 는 설정값을 검증한다. configRuntime 이 있으면 만들 때 검증한 결과를 돌려준다.
+func (conf *Config) validateConfig() error {
+	if conf.rt != nil {
+		return conf.rt.validateErr
+	}
+	return validator_v9.New().Struct(conf)
+}
+
+// checkConfigOnce 는 checkConfig 를 configRuntime 별로 한 번만 부른다.
+func (conf *Config) checkConfigOnce() error {
+	if conf.rt == nil {
+		return conf.checkConfig()
+	}
+	conf.rt.checkOnce.Do(func() {
+		conf.rt.checkErr = conf.checkConfig()
+	})
+	return conf.rt.checkErr
+}
+
+// cacheManager 는 ttl 별로 cache manager 를 재사용한다.
+func (rt *configRuntime) cacheManager(ttl int) (cache.CacheInterface[any], *marshaler.Marshaler, error) {
+	conf := rt.conf
+
+	var client redis.UniversalClient
+	if conf.redisStrategy() != "" {
+		var err error
+		// 쓰는 동안 닫히지 않도록 요청마다 redisClients 에서 얻는다
+		client, err = conf.newRedisClient()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cached, ok := rt.managers.Load(ttl); ok {
+		entry := cached.(*runtimeCacheManager)
+		if entry.client == client {
+			return entry.manager, entry.marshal, nil
+		}
+	}
+
+	manager, marshal, err := conf.buildCacheManager(ttl, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &runtimeCacheManager{client: client, manager: manager, marshal: marshal}
+	if _, ok := rt.managers.Load(ttl); ok || rt.managerCount.Load() < maxRuntimeCacheManagers {
+		if _, loaded := rt.managers.Swap(ttl, entry); !loaded {
+			rt.managerCount.Add(1)
+		}
+	}
+	return manager, marshal, nil
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_initialized(t *testing.T) {
+	conf := newInMemoryConfigForTest()
+	conf.CacheTTL = 10
+	conf.Filters = []Filter{{Name: "runtime", Rules: []Rule{{Regexp: "^/runtime/"}}}}
+
+	initialized := conf.initialized()
+	require.NotNil(t, initialized.rt)
+	require.NotNil(t, initialized.logger)
+	assert.Equal(t, 10, initialized.Filters[0].CacheTTL)
+	assert.NotEmpty(t, initialized.CacheVersion)
+
+	// 원본은 고치지 않는다
+	assert.Nil(t, conf.logger)
+	assert.Nil(t, conf.rt)
+	assert.Equal(t, 0, conf.Filters[0].CacheTTL)
+	assert.Empty(t, conf.CacheVersion)
+
+	// 같은 설정값이면 같은 사본을 쓴다
+	assert.Same(t, initialized, conf.initialized())
+	assert.Same(t, initialized, initialized.initialized())
+	same := newInMemoryConfigForTest()
+	same.CacheTTL = 10
+	same.Filters = []Filter{{Name: "runtime", Rules: []Rule{{Regexp: "^/runtime/"}}}}
+	assert.Same(t, initialized, same.initialized())
+
+	changed := *conf
+	changed.CacheTTL = 20
+	assert.NotSame(t, initialized, changed.initialized())
+
+	assert.Same(t, initialized.rt.regexps["^/runtime/"], initialized.compiledRegexp("^/runtime/"))
+	assert.True(t, initialized.compiledRegexp("^/other/").MatchString("/other/"))
+	assert.Same(t, initialized.validator(), initialized.validator())
+	assert.NoError(t, initialized.validateConfig())
+	assert.NoError(t, initialized.checkConfigOnce())
+
+	// purgeScope 의 해시는 만들 때 한 번 구한다
+	hash, err := conf.purgeScopeHash()
+	require.NoError(t, err)
+	assert.Equal(t, hash, initialized.rt.purgeHash)
+	got, err := initialized.purgeScopeHash()
+	require.NoError(t, err)
+	assert.Equal(t, hash, got)
+}
+
+func TestConfigRuntime_cacheManager(t *testing.T) {
+	t.Run("in-memory", func(t *testing.T) {
+		conf := newInMemoryConfigForTest().initialized()
+
+		manager, marshal, err := conf.newCacheManager(60)
+		require.NoError(t, err)
+		again, againMarshal, err := conf.newCacheManager(60)
+		require.NoError(t, err)
+		assert.Same(t, manager, again)
+		assert.Same(t, marshal, againMarshal)
+
+		other, _, err := conf.newCacheManager(120)
+		require.NoError(t, err)
+		assert.NotSame(t, manager, other)
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		cfg, _ := newRedisConfigForTest(t)
+		conf := cfg.initialized()
+
+		manager, _, err := conf.newCacheManager(60)
+		require.NoError(t, err)
+		again, _, err := conf.newCacheManager(60)
+		require.NoError(t, err)
+		assert.Same(t, manager, again)
+
+		// redisClients 가 클라이언트를 닫았다면 새 클라이언트로 다시 만든다
+		redisClients.sweep(time.Now().Add(redisClientIdleTimeout + time.Second))
+		rebuilt, marshal, err := conf.newCacheManager(60)
+		require.NoError(t, err)
+		assert.NotSame(t, manager, rebuilt)
+		require.NoError(t, marshal.Set(context.Background(), "runtime-redis", &CacheValue{Status: 200, TTL: 60}))
+		assert.NotNil(t, getCacheValue(context.Background(), marshal, "runtime-redis"))
+	})
+}
+
+func TestConfigRuntimeRegistry(t *testing.T) {
+	conf := newInMemoryConfigForTest()
+	conf.CacheTTL = 30
+	conf.Filters = []Filter{{Name: "registry", Rules: []Rule{{Regexp: "^/registry/"}}}}
+
+	initialized := conf.initialized()
+	// 한 번 찾은 Config 는 해시하지 않고 찾는다
+	assert.Same(t, initialized.rt, configRuntimes.lookup(conf))
+	same := *conf
+	assert.Nil(t, configRuntimes.lookup(&same))
+	assert.Same(t, initialized, same.initialized())
+	assert.Same(t, initialized.rt, configRuntimes.lookup(&same))
+
+	// 쓰는 동안은 치우지 않는다
+	configRuntimes.sweep(time.Now())
+	assert.Same(t, initialized, conf.initialized())
+
+	// 오래 쓰지 않은 configRuntime 은 치우고 다시 만든다
+	configRuntimes.sweep(time.Now().Add(configRuntimeIdleTimeout + time.Second))
+	assert.Nil(t, configRuntimes.lookup(conf))
+	assert.Nil(t, configRuntimes.lookup(&same))
+	rebuilt := conf.initialized()
+	assert.NotSame(t, initialized, rebuilt)
+	assert.Same(t, rebuilt, same.initialized())
+}
+
+func TestConfigRuntimeRegistry_sweepTargets(t *testing.T) {
+	enablePurgeForTest(t)
+	conf, _ := newPubSubConfigForTest(t, "in-memory")
+
+	// 같은 스토어와 채널을 쓰는 두 설정값
+	idle, used := *conf, *conf
+	idle.CacheTTL = 10
+	used.CacheTTL = 20
+	idleConf, usedConf := idle.initialized(), used.initialized()
+	for _, c := range []*Config{idleConf, usedConf} {
+		c.registerPurgeTarget()
+		c.subscribeInvalidation()
+	}
+	hash, err := idleConf.purgeScopeHash()
+	require.NoError(t, err)
+	registered, _ := purgeTargets.Load(hash)
+	require.Same(t, idleConf, registered)
+
+	subscriber := func() *invalidationSubscriber {
+		var found *invalidationSubscriber
+		invalidationSubscribers.Range(func(_, value any) bool {
+			found = value.(*invalidationSubscriber)
+			return false
+		})
+		return found
+	}
+	require.NotNil(t, subscriber())
+	require.Same(t, idleConf.logger, subscriber().logger.Load())
+
+	// 구독에 더한 설정값은 요청마다 해시하지 않고 건너뛴다
+	_, target, err := conf.invalidationRedisConfig().redisClientKey()
+	require.NoError(t, err)
+	assert.Equal(t, target, usedConf.rt.invalidationTarget)
+	assert.True(t, idleConf.rt.subscribed.Load())
+	assert.True(t, usedConf.rt.subscribed.Load())
+
+	// 치운 설정값 대신 같은 스토어를 쓰는 남은 설정값을 기억하고, 구독은 남은 설정값의 logger 를 쓴다
+	now := time.Now().Add(configRuntimeIdleTimeout + time.Second)
+	usedConf.rt.lastUsed.Store(now.UnixNano())
+	configRuntimes.sweep(now)
+	registered, _ = purgeTargets.Load(hash)
+	assert.Same(t, usedConf, registered)
+	registered, _ = subscriber().targets.Load(hash)
+	assert.Same(t, usedConf, registered)
+	assert.Same(t, usedConf.logger, subscriber().logger.Load())
+
+	// 남은 설정값이 없으면 구독을 끝낸다
+	configRuntimes.sweep(now.Add(configRuntimeIdleTimeout + time.Second))
+	_, ok := purgeTargets.Load(hash)
+	assert.False(t, ok)
+	assert.Nil(t, subscriber())
+}
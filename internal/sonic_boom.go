@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,7 +29,6 @@ import (
 	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
-	"github.com/umisama/go-regexpcache"
 	"gopkg.in/go-playground/validator.v9"
 
 	"go.opentelemetry.io/otel"
@@ -41,51 +42,86 @@ var (
 
 	// InMemory 설정값별로 캐시를 관리하기 위한 맵
 	ristrettoClients sync.Map // map[InMemoryConfig]*ristretto.Cache
-	cacheStores      sync.Map // map[InMemoryConfig]store.StoreInterface[any]
 
 	tracer      = otel.Tracer("sonic-boom")
 	otelEnabled = os.Getenv("OTEL_SDK_DISABLED") != "true"
 )
 
-// TODO cache control 은 나중에 구현하자
 type Config struct {
-	ResponseCodes        []int              `json:"response_code" validate:"required,gte=0" default:"[200, 301, 404]"`
-	RequestMethods       []string           `json:"request_method" validate:"required" default:"[\"GET\", \"HEAD\"]"`
-	ContentTypes         []string           `json:"content_type" validate:"required" default:"[\"text/plain\", \"application/json\", \"application/json; charset=utf-8\"]"`
-	VaryHeaders          []string           `json:"vary_headers" validate:"required" default:"[]"`
-	Filters              []Filter           `json:"filters" validate:"required" default:"[]"`
-	CacheTTL             int                `json:"cache_ttl" validate:"gte=0" default:"0"`
-	CacheControl         bool               `json:"cache_control" validate:"" default:"false"`
-	CacheableBodyMaxSize int                `json:"cacheable_body_max_size" validate:"gte=0" default:"0"`
-	CacheVersion         string             `json:"cache_version" validate:"" default:""`
-	Strategy             string             `json:"strategy" validate:"required,oneof=redis redis-cluster in-memory" default:"redis"`
-	Redis                RedisConfig        `json:"redis" default:"{}"`
-	RedisCluster         RedisClusterConfig `json:"redis_cluster" default:"{}"`
-	InMemory             InMemoryConfig     `json:"in_memory" default:"{}"`
-	LogConf              LogConfig          `json:"log" validate:"" default:"{}"`
+	ResponseCodes        []int               `json:"response_code" validate:"required,gte=0" default:"[200, 301, 404]"`
+	RequestMethods       []string            `json:"request_method" validate:"required" default:"[\"GET\", \"HEAD\"]"`
+	ContentTypes         []string            `json:"content_type" validate:"required" default:"[\"text/plain\", \"application/json\", \"application/json; charset=utf-8\"]"`
+	VaryHeaders          []string            `json:"vary_headers" validate:"required" default:"[]"`
+	Filters              []Filter            `json:"filters" validate:"required" default:"[]"`
+	CacheTTL             int                 `json:"cache_ttl" validate:"gte=0" default:"0"`
+	StorageTTL           int                 `json:"storage_ttl" validate:"gte=0" default:"0"`
+	CacheControl         bool                `json:"cache_control" validate:"" default:"false"`
+	IgnoreURICase        bool                `json:"ignore_uri_case" validate:"" default:"false"`
+	NormalizePath        bool                `json:"normalize_path" validate:"" default:"false"`
+	QueryArgs            []string            `json:"query_args" validate:""`
+	IgnoredQueryArgs     []string            `json:"ignored_query_args" validate:""`
+	NormalizeQuery       bool                `json:"normalize_query" validate:"" default:"false"`
+	CanonicalJSONBody    bool                `json:"canonical_json_body" validate:"" default:"false"`
+	IgnoredJSONPaths     []string            `json:"ignored_json_paths" validate:""`
+	GraphQL              bool                `json:"graphql" validate:"" default:"false"`
+	Idempotency          bool                `json:"idempotency" validate:"" default:"false"`
+	IdempotencyTTL       int                 `json:"idempotency_ttl" validate:"gte=0" default:"86400"`
+	IdempotencyLockMs    int                 `json:"idempotency_lock_ms" validate:"gte=0" default:"60000"`
+	InvalidateOnUnsafe   bool                `json:"invalidate_on_unsafe_methods" validate:"" default:"false"`
+	TagHeader            string              `json:"tag_header" validate:"" default:""`
+	InvalidationChannel  string              `json:"invalidation_channel" validate:"" default:""`
+	InvalidationRedis    string              `json:"invalidation_redis" validate:"oneof=redis redis-cluster redis-sentinel" default:"redis"`
+	StaleWhileRevalidate int                 `json:"stale_while_revalidate" validate:"gte=0" default:"0"`
+	StaleIfError         int                 `json:"stale_if_error" validate:"gte=0" default:"0"`
+	CollapseTimeoutMs    int                 `json:"collapse_timeout_ms" validate:"gte=0" default:"0"`
+	CacheableBodyMaxSize int                 `json:"cacheable_body_max_size" validate:"gte=0" default:"0"`
+	CacheVersion         string              `json:"cache_version" validate:"" default:""`
+	Strategy             string              `json:"strategy" validate:"required,oneof=redis redis-cluster redis-sentinel in-memory tiered" default:"redis"`
+	Redis                RedisConfig         `json:"redis" default:"{}"`
+	RedisCluster         RedisClusterConfig  `json:"redis_cluster" default:"{}"`
+	RedisSentinel        RedisSentinelConfig `json:"redis_sentinel" default:"{}"`
+	InMemory             InMemoryConfig      `json:"in_memory" default:"{}"`
+	Tiered               TieredConfig        `json:"tiered" default:"{}"`
+	LogConf              LogConfig           `json:"log" validate:"" default:"{}"`
 
 	logger *Logger `validate:"-"`
+	// rt 는 initialized 가 돌려준 사본에만 있다
+	rt *configRuntime `validate:"-"`
 }
 
 type Filter struct {
-	Name     string `json:"name" validate:"required" default:""`
-	Rules    []Rule `json:"rules" validate:"required" default:""`
-	CacheTTL int    `json:"cache_ttl" validate:"gte=0" default:"0"`
+	Name       string `json:"name" validate:"required" default:""`
+	Rules      []Rule `json:"rules" validate:"required" default:""`
+	CacheTTL   int    `json:"cache_ttl" validate:"gte=0" default:"0"`
+	StorageTTL int    `json:"storage_ttl" validate:"gte=0" default:"0"`
+	// 비어 있으면 Config 의 값을 쓴다
+	QueryArgs        []string `json:"query_args" validate:""`
+	IgnoredQueryArgs []string `json:"ignored_query_args" validate:""`
+	// 비어 있으면 Config 의 값을 쓰고, false 로 두면 Config 에서 켜도 끈다
+	NormalizeQuery *bool `json:"normalize_query" validate:""`
+	// 설정하면 요청 본문 대신 JSON 본문에서 뽑은 값으로 캐시 키를 만든다
+	JSONPaths []string `json:"json_paths" validate:""`
 }
 
 type Rule struct {
 	Header string `json:"header" validate:"" default:""`
-	Regexp string `json:"regexp" validate:"required"`
+	// graphql 모드에서 Regexp 를 GraphQL operationName 에 맞춘다
+	OperationName bool   `json:"operation_name" validate:"" default:"false"`
+	Regexp        string `json:"regexp" validate:"required"`
 }
 
 func (r *Rule) pathRule() bool {
-	return r.Header == ""
+	return r.Header == "" && !r.OperationName
 }
 
 func (r *Rule) headerRule() bool {
 	return r.Header != ""
 }
 
+func (r *Rule) operationNameRule() bool {
+	return r.Header == "" && r.OperationName
+}
+
 func New() interface{} {
 	config := &Config{}
 	if err := defaults.Set(config); err != nil {
@@ -115,10 +151,28 @@ func (conf *Config) Init() {
 	conf.logger = NewLogger(&conf.LogConf)
 
 	// 나머지 설정들 초기화
-	for _, filter := range conf.Filters {
+	conf.IgnoredQueryArgs = conf.validIgnoredQueryArgs(conf.IgnoredQueryArgs)
+	conf.IgnoredJSONPaths = conf.validJSONPaths("ignored_json_paths", conf.IgnoredJSONPaths)
+	for i := range conf.Filters {
+		filter := &conf.Filters[i]
+		filter.IgnoredQueryArgs = conf.validIgnoredQueryArgs(filter.IgnoredQueryArgs)
+		filter.JSONPaths = conf.validJSONPaths("json_paths", filter.JSONPaths)
 		if defaults.CanUpdate(filter.CacheTTL) {
 			filter.CacheTTL = conf.CacheTTL
 		}
+		if defaults.CanUpdate(filter.StorageTTL) {
+			filter.StorageTTL = conf.StorageTTL
+		}
+		if filter.QueryArgs == nil {
+			filter.QueryArgs = conf.QueryArgs
+		}
+		if filter.IgnoredQueryArgs == nil {
+			filter.IgnoredQueryArgs = conf.IgnoredQueryArgs
+		}
+		if filter.NormalizeQuery == nil {
+			normalizeQuery := conf.NormalizeQuery
+			filter.NormalizeQuery = &normalizeQuery
+		}
 	}
 
 	if conf.CacheVersion == "" {
@@ -143,12 +197,40 @@ func convertRedisTimeout(timeout int, timeUnit time.Duration) time.Duration {
 	return time.Duration(timeout) * timeUnit
 }
 
-func (conf *Config) newCacheManager(ttl int) (*cache.Cache[any], *marshaler.Marshaler, error) {
+// newRedisClient 는 redis, redis-cluster, redis-sentinel 전략에 맞는 클라이언트를 돌려준다.
+// 같은 설정값이면 요청마다 만들지 않고 redisClients 의 클라이언트를 함께 쓰므로 닫으면 안 된다.
+func (conf *Config) newRedisClient() (redis.UniversalClient, error) {
+	if conf.rt != nil {
+		if conf.rt.redisErr != nil {
+			return nil, conf.rt.redisErr
+		}
+		return redisClients.acquire(conf.rt.redisKey, conf.rt.redisTarget, conf.buildRedisClient)
+	}
+
+	key, target, err := conf.redisClientKey()
+	if err != nil {
+		return nil, err
+	}
+	return redisClients.acquire(key, target, conf.buildRedisClient)
+}
+
+// redisStrategy 는 L2 로 쓰는 Redis 의 종류다. tiered 전략은 Tiered.L2 를, Redis 를 쓰지 않으면 "" 를 돌려준다.
+func (conf *Config) redisStrategy() string {
 	switch conf.Strategy {
+	case "redis", "redis-cluster", "redis-sentinel":
+		return conf.Strategy
+	case "tiered":
+		return conf.Tiered.L2
+	default:
+		return ""
+	}
+}
+
+// buildRedisClient 는 새 클라이언트를 만든다. go-redis 가 자체적으로 pooling 을 제공한다
+func (conf *Config) buildRedisClient() (redis.UniversalClient, error) {
+	switch conf.redisStrategy() {
 	case "redis":
-		// Redis는 매번 새로운 인스턴스 생성
-		// go-redis 가 자체적으로 pooling 을 제공한다
-		redisClient := redis.NewClient(&redis.Options{
+		return redis.NewClient(&redis.Options{
 			Addr:            conf.Redis.Host + ":" + strconv.Itoa(conf.Redis.Port),
 			Username:        conf.Redis.Username,
 			Password:        conf.Redis.Password,
@@ -162,14 +244,10 @@ func (conf *Config) newCacheManager(ttl int) (*cache.Cache[any], *marshaler.Mars
 			WriteTimeout:    convertRedisTimeout(conf.Redis.WriteTimeout, time.Second),
 			PoolTimeout:     convertRedisTimeout(conf.Redis.PoolTimeout, time.Second),
 			ConnMaxIdleTime: convertRedisTimeout(conf.Redis.IdleTimeout, time.Second),
-		})
-		cacheStore := redis_store.NewRedis(redisClient, lib_store.WithExpiration(time.Duration(ttl)*time.Second))
-		cacheManager := cache.New[any](cacheStore)
-		marshal := marshaler.New(cacheManager)
-		return cacheManager, marshal, nil
+		}), nil
 
 	case "redis-cluster":
-		redisClient := redis.NewClusterClient(&redis.ClusterOptions{
+		return redis.NewClusterClient(&redis.ClusterOptions{
 			Addrs:           conf.RedisCluster.Addrs,
 			Username:        conf.RedisCluster.Username,
 			Password:        conf.RedisCluster.Password,
@@ -182,21 +260,98 @@ func (conf *Config) newCacheManager(ttl int) (*cache.Cache[any], *marshaler.Mars
 			WriteTimeout:    convertRedisTimeout(conf.RedisCluster.WriteTimeout, time.Second),
 			PoolTimeout:     convertRedisTimeout(conf.RedisCluster.PoolTimeout, time.Second),
 			ConnMaxIdleTime: convertRedisTimeout(conf.RedisCluster.IdleTimeout, time.Second),
-		})
-		cacheStore := rediscluster_store.NewRedisCluster(redisClient, lib_store.WithExpiration(time.Duration(ttl)*time.Second))
-		cacheManager := cache.New[any](cacheStore)
-		marshal := marshaler.New(cacheManager)
-		return cacheManager, marshal, nil
+		}), nil
+
+	case "redis-sentinel":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       conf.RedisSentinel.MasterName,
+			SentinelAddrs:    conf.RedisSentinel.SentinelAddrs,
+			SentinelUsername: conf.RedisSentinel.SentinelUsername,
+			SentinelPassword: conf.RedisSentinel.SentinelPassword,
+			Username:         conf.RedisSentinel.Username,
+			Password:         conf.RedisSentinel.Password,
+			DB:               conf.RedisSentinel.DBNumber,
+			PoolSize:         conf.RedisSentinel.PoolSize,
+			MaxRetries:       conf.RedisSentinel.MaxRetries,
+			MinRetryBackoff:  convertRedisTimeout(conf.RedisSentinel.MinRetryBackoffMs, time.Millisecond),
+			MaxRetryBackoff:  convertRedisTimeout(conf.RedisSentinel.MaxRetryBackoffMs, time.Millisecond),
+			DialTimeout:      convertRedisTimeout(conf.RedisSentinel.DialTimeout, time.Second),
+			ReadTimeout:      convertRedisTimeout(conf.RedisSentinel.ReadTimeout, time.Second),
+			WriteTimeout:     convertRedisTimeout(conf.RedisSentinel.WriteTimeout, time.Second),
+			PoolTimeout:      convertRedisTimeout(conf.RedisSentinel.PoolTimeout, time.Second),
+			ConnMaxIdleTime:  convertRedisTimeout(conf.RedisSentinel.IdleTimeout, time.Second),
+		}), nil
+
+	default:
+		return nil, fmt.Errorf("strategy %s does not use redis", conf.Strategy)
+	}
+}
+
+// newCacheManager 는 ttl 을 기본 만료 기간으로 쓰는 cache manager 를 돌려준다.
+// initialized 가 돌려준 사본이면 configRuntime 의 cache manager 를 재사용한다.
+func (conf *Config) newCacheManager(ttl int) (cache.CacheInterface[any], *marshaler.Marshaler, error) {
+	if conf.rt != nil {
+		return conf.rt.cacheManager(ttl)
+	}
+	return conf.buildCacheManager(ttl, nil)
+}
+
+// buildCacheManager 는 새 cache manager 를 만든다. redisClient 가 nil 이면 redisClients 에서 얻는다.
+func (conf *Config) buildCacheManager(ttl int, redisClient redis.UniversalClient) (cache.CacheInterface[any], *marshaler.Marshaler, error) {
+	if redisClient == nil && conf.Strategy != "in-memory" {
+		var err error
+		redisClient, err = conf.newRedisClient()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	expiration := time.Duration(ttl) * time.Second
+	var cacheManager cache.CacheInterface[any]
+	switch conf.Strategy {
+	case "redis", "redis-cluster", "redis-sentinel":
+		cacheManager = cache.New[any](conf.redisStore(redisClient, lib_store.WithExpiration(expiration)))
 
 	case "in-memory":
-		// 기존 캐시 스토어가 있는지 확인
-		if existingStore, ok := cacheStores.Load(conf.InMemory); ok {
-			cacheStore := existingStore.(lib_store.StoreInterface) // store.StoreInterface를 lib_store.StoreInterface로 변경
-			cacheManager := cache.New[any](cacheStore)
-			marshal := marshaler.New(cacheManager)
-			return cacheManager, marshal, nil
+		// ristretto 캐시는 설정값별로 재사용하고, 스토어는 ttl 이 반영되도록 매번 감싼다
+		client, err := conf.ristrettoClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		cacheManager = cache.New[any](ristretto_store.NewRistretto(client, lib_store.WithExpiration(expiration)))
+
+	case "tiered":
+		client, err := conf.ristrettoClient()
+		if err != nil {
+			return nil, nil, err
 		}
+		cacheManager = newTieredCache(
+			ristretto_store.NewRistretto(client),
+			conf.redisStore(redisClient),
+			expiration,
+			time.Duration(conf.Tiered.L1TTL)*time.Second,
+		)
+
+	default:
+		return nil, nil, fmt.Errorf("unknown cache strategy: %s", conf.Strategy)
+	}
+
+	marshal := marshaler.New(cacheManager)
+	return cacheManager, marshal, nil
+}
+
+// redisStore 는 redisStrategy 에 맞는 Redis 스토어를 만든다.
+func (conf *Config) redisStore(client redis.UniversalClient, options ...lib_store.Option) lib_store.StoreInterface {
+	if conf.redisStrategy() == "redis-cluster" {
+		return rediscluster_store.NewRedisCluster(client, options...)
+	}
+	return redis_store.NewRedis(client, options...)
+}
 
+// ristrettoClient 는 InMemory 설정값별로 재사용하는 ristretto 캐시를 돌려준다.
+func (conf *Config) ristrettoClient() (*ristretto.Cache, error) {
+	actualClient, ok := ristrettoClients.Load(conf.InMemory)
+	if !ok {
 		// 새로운 캐시 생성
 		config := &ristretto.Config{
 			MaxCost:     int64(conf.InMemory.MaxCost),
@@ -204,28 +359,19 @@ func (conf *Config) newCacheManager(ttl int) (*cache.Cache[any], *marshaler.Mars
 			BufferItems: int64(conf.InMemory.BufferItems),
 		}
 
-		// LoadOrStore를 사용하여 동시성 안전하게 생성
 		client, err := ristretto.NewCache(config)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create ristretto cache: %v", err)
+			return nil, fmt.Errorf("failed to create ristretto cache: %v", err)
 		}
 
-        actualClient, _ := ristrettoClients.LoadOrStore(conf.InMemory, client)
-        cacheStore := ristretto_store.NewRistretto(
-            actualClient.(*ristretto.Cache),
-            lib_store.WithExpiration(time.Duration(ttl)*time.Second),
-        )
-
-		// 생성된 store를 저장
-		cacheStores.Store(conf.InMemory, cacheStore)
-
-		cacheManager := cache.New[any](cacheStore)
-		marshal := marshaler.New(cacheManager)
-		return cacheManager, marshal, nil
-
-	default:
-		return nil, nil, fmt.Errorf("unknown cache strategy: %s", conf.Strategy)
+		// LoadOrStore를 사용하여 동시성 안전하게 생성
+		var loaded bool
+		actualClient, loaded = ristrettoClients.LoadOrStore(conf.InMemory, client)
+		if loaded {
+			client.Close()
+		}
 	}
+	return actualClient.(*ristretto.Cache), nil
 }
 
 func (conf *Config) Access(kong *pdk.PDK) {
@@ -241,10 +387,12 @@ func (conf *Config) Access(kong *pdk.PDK) {
 		}
 	}()
 
-	conf.Init()
-	defer conf.Close() //nolint directives: gosimple
+	// Init 과 logger 는 설정값별로 한 번만 만든다
+	conf = conf.initialized()
 
 	logger := conf.logger
+	conf.registerPurgeTarget()
+	conf.subscribeInvalidation()
 
 	method, err := kong.Request.GetMethod()
 	if err != nil {
@@ -277,7 +425,7 @@ func (conf *Config) Access(kong *pdk.PDK) {
 	}
 
 	if conf.isDebug() {
-		if err := conf.checkConfig(); err != nil {
+		if err := conf.checkConfigOnce(); err != nil {
 			logger.Fatal().Err(err).Msg("Config check failed")
 			return
 		}
@@ -287,7 +435,39 @@ func (conf *Config) Access(kong *pdk.PDK) {
 		}
 	}
 
-	cacheable, cacheTTL := conf.cacheableRequest(kong)
+	if key := conf.idempotencyKey(kong, method); key != "" {
+		conf.idempotent(kong, key, method, uri)
+		return
+	}
+
+	if conf.invalidatesOn(method) {
+		path := conf.cacheKeyPath(uri)
+		signal := CacheSignal{CacheKeyID: pathIndexName(path), Path: path, Invalidate: true}
+		if err := conf.signalCacheReqWithStatus(kong, signal, "Bypass"); err != nil {
+			logger.Error().Err(err).Msg("Failed to signal cache request")
+		}
+		return
+	}
+
+	reqCC := conf.requestCacheControl(kong)
+
+	// graphql 모드에서는 Filter 규칙에 operationName 을 쓰므로 본문을 먼저 읽는다
+	var rawBody []byte
+	var operation *graphQLOperation
+	if conf.GraphQL {
+		if rawBody, err = conf.requestRawBody(kong); err != nil {
+			return
+		}
+		if operation, err = conf.graphQLOperation(kong, method, rawBody); err != nil {
+			logger.Debug().Err(err).Msg("GraphQL request is not cacheable")
+			if err := kong.Response.SetHeader("X-Cache-Status", "Bypass"); err != nil {
+				logger.Error().Err(err).Msg("SetHeader failed")
+			}
+			return
+		}
+	}
+
+	cacheable, filter := conf.cacheableRequest(kong, reqCC, graphQLOperationName(operation))
 	if !cacheable {
 		if err := kong.Response.SetHeader("X-Cache-Status", "Bypass"); err != nil {
 			logger.Error().Err(err).Msg("SetHeader failed")
@@ -296,18 +476,41 @@ func (conf *Config) Access(kong *pdk.PDK) {
 		return
 	}
 
-	rawBody, err := kong.Request.GetRawBody()
+	if !conf.GraphQL {
+		if rawBody, err = conf.requestRawBody(kong); err != nil {
+			return
+		}
+	}
+
+	cacheTTL := filter.CacheTTL
+
+	_, marshal, err := conf.newCacheManager(cacheTTL)
 	if err != nil {
-		logger.Error().Err(err).Msg("Getting raw body has failed")
+		logger.Error().Err(err).Msg("Failed to create cache manager")
 		return
 	}
-	if rawBody == nil {
-		logger.Debug().Msg("raw body is empty")
-	} else {
-		logger.Debug().Msgf("Raw body length is %d", len(rawBody))
+
+	keyBody := rawBody
+	if operation != nil {
+		if !conf.cacheableGraphQLOperation(marshal, operation) {
+			if err := kong.Response.SetHeader("X-Cache-Status", "Bypass"); err != nil {
+				logger.Error().Err(err).Msg("SetHeader failed")
+			}
+			return
+		}
+		if keyBody, err = operation.cacheKeyBody(); err != nil {
+			logger.Error().Err(err).Msg("Failed to create cache key")
+			return
+		}
 	}
 
-	cacheKeyID, err := NewCacheKey(kong, conf, rawBody, cacheTTL)
+	cacheKey, err := newCacheKey(kong, conf, filter, keyBody)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create cache key")
+		return
+	}
+	// 업스트림이 Vary 로 알려준 요청 헤더까지 캐시 키에 반영한다
+	cacheKeyID, baseKeyID, varyHeaders, err := conf.varyCacheKey(kong, marshal, cacheKey)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to create cache key")
 		return
@@ -318,9 +521,26 @@ func (conf *Config) Access(kong *pdk.PDK) {
 		//_ = log.Err("SetHeader failed: ", err.Error())
 	}
 
-	_, marshal, err := conf.newCacheManager(cacheTTL)
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to create cache manager")
+	cacheSignal := CacheSignal{
+		CacheKeyID:  cacheKeyID,
+		CacheTTL:    cacheTTL,
+		StorageTTL:  filter.StorageTTL,
+		BaseKeyID:   baseKeyID,
+		VaryHeaders: varyHeaders,
+		Path:        cacheKey.URL,
+		Service:     cacheKey.Service,
+		Route:       cacheKey.Route,
+	}
+
+	// 백그라운드 갱신 요청은 캐시를 보지 않고 업스트림으로 보낸다
+	if conf.revalidateEnabled() && conf.isRevalidateRequest(kong) {
+		if err := kong.ServiceRequest.ClearHeader(revalidateHeader); err != nil {
+			logger.Warn().Err(err).Msgf("Failed to clear header `%s`", revalidateHeader)
+		}
+		cacheSignal.Revalidate = true
+		if err := conf.signalCacheReqWithStatus(kong, cacheSignal, "Refresh"); err != nil {
+			logger.Error().Err(err).Msg("Failed to signal cache request")
+		}
 		return
 	}
 
@@ -340,31 +560,46 @@ func (conf *Config) Access(kong *pdk.PDK) {
 
 		// this request wasn't found in the data store, but the client only wanted
 		// cache data. see https://tools.ietf.org/html/rfc7234#section-5.2.1.7
-		//if conf.cache_control and cc["only-if-cached"] then
-		//	return kong.response.exit(ngx.HTTP_GATEWAY_TIMEOUT)
-		//end
-
-		if err := SetPlugin(kong, "reqBody", rawBody); err != nil {
-			logger.Error().Err(err).Msg("Failed to set reqBody in plugin context")
+		if conf.CacheControl && reqCC.has("only-if-cached") {
+			logger.Debug().Msg("only-if-cached is requested but the cache is missed")
+			kong.Response.ExitStatus(http.StatusGatewayTimeout)
 			return
 		}
-		logger.Debug().Msg("Request body is saved to Context")
 
-		err = conf.signalCacheReq(kong, CacheSignal{cacheKeyID, cacheTTL})
-		if err != nil {
-			logger.Error().Err(err).Msg("Failed to signal cache request")
+		// 같은 키로 업스트림에 가는 요청은 하나만 두고, 나머지는 그 요청이 저장한 값을 기다린다
+		lockToken := ""
+		var filled *CacheValue
+		if conf.collapseEnabled() {
+			lockToken, filled = conf.collapse(marshal, cacheKeyID, nil)
+		}
+		if filled == nil {
+			if err := SetPlugin(kong, "reqBody", rawBody); err != nil {
+				logger.Error().Err(err).Msg("Failed to set reqBody in plugin context")
+				if lockToken != "" {
+					conf.releaseCollapse(cacheKeyID, lockToken, nil)
+				}
+				return
+			}
+			logger.Debug().Msg("Request body is saved to Context")
+
+			cacheSignal.LockToken = lockToken
+			err = conf.signalCacheReq(kong, cacheSignal)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to signal cache request")
+				if lockToken != "" {
+					conf.releaseCollapse(cacheKeyID, lockToken, nil)
+				}
+				return
+			}
 			return
 		}
-		return
+		logger.Debug().Msg("Cache is filled by a collapsed request")
+		cached = filled
 	}
 
 	logger.Debug().Msg("Cache hit")
 
 	cacheValue := cached.(*CacheValue)
-	cacheSignal := CacheSignal{
-		CacheKeyID: cacheKeyID,
-		CacheTTL:   cacheTTL,
-	}
 
 	if cacheValue.Version != conf.CacheVersion {
 		logger.Warn().Msgf("Cache version mismatch, purging: %s != %s", cacheValue.Version, conf.CacheVersion)
@@ -374,26 +609,51 @@ func (conf *Config) Access(kong *pdk.PDK) {
 		}
 		if err := conf.signalCacheReqWithStatus(kong, cacheSignal, "Bypass"); err != nil {
 			logger.Error().Err(err).Msg("Failed to signal cache request")
-			return
 		}
+		return
 	}
 
 	//-- figure out if the client will accept our cache value
-	if conf.CacheControl {
-		logger.Fatal().Msg("Cache control is enabled but not implemented yet")
+	secs := time.Now().Unix()
+	if conf.CacheControl && !acceptableToClient(reqCC, cacheValue, secs) {
+		if err := conf.signalCacheReqWithStatus(kong, cacheSignal, "Refresh"); err != nil {
+			logger.Error().Err(err).Msg("Failed to signal cache request")
+		}
 		return
-	} else {
-		//-- don't serve stale data; res may be stored for up to `conf.storage_ttl` secs
-		now := time.Now()
-		secs := now.Unix()
+	}
 
-		if (secs - cacheValue.Timestamp) > int64(conf.CacheTTL) {
-			if err := conf.signalCacheReqWithStatus(kong, cacheSignal, "Refresh"); err != nil {
-				logger.Error().Err(err).Msg("Failed to signal cache request")
+	//-- don't serve stale data; res may be stored for up to `conf.storage_ttl` secs
+	// 클라이언트가 max-stale 로 stale 한 값을 허용했다면 그대로 응답한다
+	cacheStatus := "Hit"
+	if cacheValue.stale(secs) && !(conf.CacheControl && reqCC.has("max-stale")) {
+		if !cacheValue.staleWhileRevalidate(secs) {
+			// 미스와 마찬가지로 갱신하는 요청은 하나만 두고, 나머지는 그 요청이 저장한 값을 기다린다
+			lockToken := ""
+			var filled *CacheValue
+			if conf.collapseEnabled() {
+				lockToken, filled = conf.collapse(marshal, cacheKeyID, cacheValue)
+			}
+			if filled == nil || filled.stale(secs) {
+				// 업스트림이 304 로 응답하면 Response 에서 본문 없이 갱신한다
+				cacheSignal.Conditional = conf.addRevalidationHeaders(kong, cacheValue)
+				cacheSignal.LockToken = lockToken
+				if err := conf.signalCacheReqWithStatus(kong, cacheSignal, "Refresh"); err != nil {
+					logger.Error().Err(err).Msg("Failed to signal cache request")
+					if lockToken != "" {
+						conf.releaseCollapse(cacheKeyID, lockToken, nil)
+					}
+				}
 				return
 			}
+			logger.Debug().Msg("Stale cache is refreshed by a collapsed request")
+			cacheValue = filled
+		} else {
+			cacheStatus = conf.revalidate(kong, cacheKeyID, rawBody)
 		}
 	}
+	if cacheValue.stale(secs) {
+		cacheStatus = cacheValue.staleStatus(cacheStatus)
+	}
 
 	// we have cache data yo!
 	// expose response data for logging plugins
@@ -418,65 +678,79 @@ func (conf *Config) Access(kong *pdk.PDK) {
 		return
 	}
 
-	for key := range cacheValue.Headers { //nolint:gosimple,gofmt
-		// NOTE: https://github.dev/Kong/kong/blob/master/kong/plugins/proxy-cache/handler.lua 를 베꼈는데 의미를 잘 모르겠다.
-		if !overwritableHeader(key) {
-			delete(cacheValue.Headers, key)
-		}
+	headers := cacheValue.responseHeaders(cacheStatus, secs)
 
-		if headerToDelete(key) {
-			delete(cacheValue.Headers, key)
-		}
+	// 클라이언트가 가진 값이 그대로라면 본문 없이 응답하고, 조건이 맞지 않으면 412 로 응답한다
+	switch status := evaluatePreconditions(conf.conditionalRequest(kong, method), cacheValue); status {
+	case http.StatusNotModified:
+		logger.Debug().Msg("Cached value is not modified")
+		kong.Response.Exit(status, nil, headers)
+		return
+	case http.StatusPreconditionFailed:
+		logger.Debug().Msg("Precondition failed against cached value")
+		kong.Response.Exit(status, nil, map[string][]string{"X-Cache-Status": {cacheStatus}})
+		return
 	}
 
-	now := time.Now()
-	secs := now.Unix()
-	age := strconv.FormatInt(secs-cacheValue.Timestamp, 10)
-	cacheValue.Headers["Age"] = []string{age}
-	cacheValue.Headers["X-Cache-Status"] = []string{"Hit"}
-
-	logger.Debug().Msgf("CacheValue Headers: %+v", cacheValue.Headers)
-	kong.Response.Exit(cacheValue.Status, cacheValue.Body, cacheValue.Headers)
+	logger.Debug().Msgf("CacheValue Headers: %+v", headers)
+	kong.Response.Exit(cacheValue.Status, cacheValue.Body, headers)
 }
 
-func (conf *Config) cacheableRequest(kong *pdk.PDK) (bool, int) {
+// cacheableRequest 는 요청이 캐시 대상인지와 적용할 Filter 를 돌려준다.
+// operationName 은 graphql 모드의 GraphQL operationName 이며, 아니면 빈 문자열이다.
+func (conf *Config) cacheableRequest(kong *pdk.PDK, reqCC cacheControl, operationName string) (bool, Filter) {
 	if !conf.cacheableRequestMethod(kong) {
 		conf.logger.Debug().Msg("Request method is not cacheable")
-		return false, 0
-	}
-
-	cacheable, ttl := conf.filtered(kong)
-	if cacheable {
-		return cacheable, ttl
+		return false, Filter{}
 	}
 
 	// check for explicit disallow directives
 	// TODO note that no-cache isnt quite accurate here
-	//if conf.cache_control and (cc["no-store"] or cc["no-cache"] or
-	//	ngx.var.authorization) then
-	//	return false
-	//end
+	if conf.CacheControl && (reqCC.has("no-store") || reqCC.has("no-cache") || conf.authorizedRequest(kong)) {
+		conf.logger.Debug().Msg("Request is not cacheable by Cache-Control")
+		return false, Filter{}
+	}
 
-	return false, 0
+	return conf.filtered(kong, operationName)
 }
 
-func (conf *Config) filtered(kong *pdk.PDK) (bool, int) {
+func (conf *Config) authorizedRequest(kong *pdk.PDK) bool {
+	v, err := kong.Request.GetHeader("Authorization")
+	if err != nil {
+		return false
+	}
+	return v != ""
+}
+
+// filtered 는 요청에 맞는 Filter 를 찾는다. Filter 가 없으면 설정값으로 만든 Filter 를 돌려준다.
+func (conf *Config) filtered(kong *pdk.PDK, operationName string) (bool, Filter) {
 	filters := conf.Filters
 	if len(filters) == 0 {
-		return true, conf.CacheTTL
+		return true, conf.defaultFilter()
 	}
 
 	for _, filter := range filters {
-		if conf.rulesFiltered(kong, filter.Rules) {
-			return true, filter.CacheTTL
+		if conf.rulesFiltered(kong, filter.Rules, operationName) {
+			return true, filter
 		}
 	}
 
 	conf.logger.Debug().Msg("Header does not match any filter")
-	return false, 0
+	return false, Filter{}
+}
+
+func (conf *Config) defaultFilter() Filter {
+	normalizeQuery := conf.NormalizeQuery
+	return Filter{
+		CacheTTL:         conf.CacheTTL,
+		StorageTTL:       conf.StorageTTL,
+		QueryArgs:        conf.QueryArgs,
+		IgnoredQueryArgs: conf.IgnoredQueryArgs,
+		NormalizeQuery:   &normalizeQuery,
+	}
 }
 
-func (conf *Config) rulesFiltered(kong *pdk.PDK, rules []Rule) bool {
+func (conf *Config) rulesFiltered(kong *pdk.PDK, rules []Rule, operationName string) bool {
 	for _, rule := range rules {
 		if rule.pathRule() {
 			if ok := conf.cacheablePath(kong, rule); !ok {
@@ -486,6 +760,10 @@ func (conf *Config) rulesFiltered(kong *pdk.PDK, rules []Rule) bool {
 			if ok := conf.cacheableHeader(kong, rule); !ok {
 				return false
 			}
+		} else if rule.operationNameRule() {
+			if ok := conf.cacheableOperationName(operationName, rule); !ok {
+				return false
+			}
 		} else {
 			panic(fmt.Sprintf("Unknown rule type: %T", rule))
 		}
@@ -503,7 +781,7 @@ func (conf *Config) cacheablePath(kong *pdk.PDK, rule Rule) bool {
 		return false
 	}
 
-	r := regexpcache.MustCompile(rule.Regexp)
+	r := conf.compiledRegexp(rule.Regexp)
 	if r.MatchString(path) {
 		conf.logger.Debug().Msgf("Path %s is cacheable", path)
 		return true
@@ -529,7 +807,7 @@ func (conf *Config) cacheableHeader(kong *pdk.PDK, rule Rule) bool {
 		return false
 	}
 
-	r := regexpcache.MustCompile(rule.Regexp)
+	r := conf.compiledRegexp(rule.Regexp)
 	if r.MatchString(v) {
 		return true
 	}
@@ -538,6 +816,25 @@ func (conf *Config) cacheableHeader(kong *pdk.PDK, rule Rule) bool {
 	return false
 }
 
+func (conf *Config) cacheableOperationName(operationName string, rule Rule) bool {
+	if !rule.operationNameRule() {
+		panic("Rule is not an operation name rule")
+	}
+
+	if operationName == "" {
+		conf.logger.Debug().Msg("GraphQL operation name is empty")
+		return false
+	}
+
+	r := conf.compiledRegexp(rule.Regexp)
+	if r.MatchString(operationName) {
+		return true
+	}
+
+	conf.logger.Debug().Msgf("GraphQL operation %s is not cacheable", operationName)
+	return false
+}
+
 func (conf *Config) cacheableRequestMethod(kong *pdk.PDK) bool {
 	method, err := kong.Request.GetMethod()
 	if err != nil {
@@ -592,8 +889,14 @@ func (conf *Config) checkConfig() error {
 	validate.RegisterStructValidation(func(sl validator.StructLevel) {
 		config := sl.Current().Interface().(Config)
 
+		// in-memory 전략도 invalidation_channel 이 있으면 Redis 를 쓴다
+		redisStrategy := config.redisStrategy()
+		if redisStrategy == "" && config.broadcastsInvalidation() {
+			redisStrategy = config.InvalidationRedis
+		}
+
 		// Redis strategy일 때 Redis 설정 검증
-		if config.Strategy == "redis" {
+		if redisStrategy == "redis" {
 			if config.Redis.Host == "" {
 				sl.ReportError(config.Redis.Host, "Host", "Redis.Host", "required", "")
 			}
@@ -603,11 +906,45 @@ func (conf *Config) checkConfig() error {
 		}
 
 		// Redis Cluster strategy일 때 RedisCluster 설정 검증
-		if config.Strategy == "redis-cluster" {
+		if redisStrategy == "redis-cluster" {
 			if len(config.RedisCluster.Addrs) == 0 {
 				sl.ReportError(config.RedisCluster.Addrs, "Addrs", "RedisCluster.Addrs", "required", "")
 			}
 		}
+
+		// Redis Sentinel strategy일 때 RedisSentinel 설정 검증
+		if redisStrategy == "redis-sentinel" {
+			if config.RedisSentinel.MasterName == "" {
+				sl.ReportError(config.RedisSentinel.MasterName, "MasterName", "RedisSentinel.MasterName", "required", "")
+			}
+			if len(config.RedisSentinel.SentinelAddrs) == 0 {
+				sl.ReportError(config.RedisSentinel.SentinelAddrs, "SentinelAddrs", "RedisSentinel.SentinelAddrs", "required", "")
+			}
+		}
+
+		// 잘못된 정규식은 요청마다 panic 을 일으키므로 미리 막는다
+		for _, pattern := range config.IgnoredQueryArgs {
+			if _, err := ignoredQueryArgRegexp(pattern); err != nil {
+				sl.ReportError(config.IgnoredQueryArgs, "IgnoredQueryArgs", "IgnoredQueryArgs", "regexp", pattern)
+			}
+		}
+		for _, expr := range config.IgnoredJSONPaths {
+			if _, err := parseJSONPath(expr); err != nil {
+				sl.ReportError(config.IgnoredJSONPaths, "IgnoredJSONPaths", "IgnoredJSONPaths", "jsonpath", expr)
+			}
+		}
+		for _, filter := range config.Filters {
+			for _, pattern := range filter.IgnoredQueryArgs {
+				if _, err := ignoredQueryArgRegexp(pattern); err != nil {
+					sl.ReportError(filter.IgnoredQueryArgs, "IgnoredQueryArgs", "Filters.IgnoredQueryArgs", "regexp", pattern)
+				}
+			}
+			for _, expr := range filter.JSONPaths {
+				if _, err := parseJSONPath(expr); err != nil {
+					sl.ReportError(filter.JSONPaths, "JSONPaths", "Filters.JSONPaths", "jsonpath", expr)
+				}
+			}
+		}
 	}, Config{})
 
 	return validate.Struct(conf)
@@ -639,6 +976,22 @@ func (conf *Config) signalCacheReqWithStatus(kong *pdk.PDK, signal CacheSignal,
 	return nil
 }
 
+func (conf *Config) requestRawBody(kong *pdk.PDK) ([]byte, error) {
+	logger := conf.logger
+
+	rawBody, err := kong.Request.GetRawBody()
+	if err != nil {
+		logger.Error().Err(err).Msg("Getting raw body has failed")
+		return nil, err
+	}
+	if rawBody == nil {
+		logger.Debug().Msg("raw body is empty")
+	} else {
+		logger.Debug().Msgf("Raw body length is %d", len(rawBody))
+	}
+	return rawBody, nil
+}
+
 // NOTE kong.Request.GetRawBody() 의 구현을 베꼈다
 func serviceResponseRawBody(kong *pdk.PDK) ([]byte, error) {
 	out := new(kong_plugin_protocol.RawBodyResult)
@@ -663,8 +1016,8 @@ func serviceResponseRawBody(kong *pdk.PDK) ([]byte, error) {
 }
 
 func (conf *Config) Response(kong *pdk.PDK) {
-	conf.Init()
-defer conf.Close() //nolint directives: gosimple
+	// Init 과 logger 는 설정값별로 한 번만 만든다
+	conf = conf.initialized()
 
 	logger := conf.logger
 
@@ -703,6 +1056,8 @@ defer conf.Close() //nolint directives: gosimple
 
 	logger.Debug().Msg("Response is called")
 
+	tags := conf.responseTags(kong)
+
 	cacheSignal := CacheSignal{}
 	err = GetPluginAnyEx(kong, "cacheSignal", &cacheSignal)
 	if err != nil {
@@ -716,8 +1071,37 @@ defer conf.Close() //nolint directives: gosimple
 	logger.Debug().Msgf("cacheKeyID type is %s", reflect.TypeOf(cacheSignal))
 	logger.Debug().Msgf("cacheKeyID is found: %v", cacheSignal)
 
+	// 캐시 미스를 모으는 잠금은 저장하지 못했더라도 풀어서 기다리는 요청을 보낸다. Idempotency-Key 의 잠금도 여기서 푼다
+	var stored *CacheValue
+	if cacheSignal.LockToken != "" {
+		defer func() {
+			conf.releaseCollapse(cacheSignal.CacheKeyID, cacheSignal.LockToken, stored)
+		}()
+	}
+
+	if cacheSignal.Invalidate {
+		conf.invalidateUnsafe(kong, httpStatus, cacheSignal.Path)
+		if !cacheSignal.Idempotency {
+			return
+		}
+	}
+
+	if cacheSignal.Idempotency {
+		stored = conf.storeIdempotent(kong, httpStatus, cacheSignal)
+		return
+	}
+
+	if httpStatus == http.StatusNotModified && cacheSignal.Conditional {
+		stored = conf.refreshNotModified(kong, cacheSignal)
+		return
+	}
+
 	// ProxyCacheHandler:header_filter
 	if !conf.cacheableResponse(kong) {
+		if conf.serveStaleIfError(kong, httpStatus, cacheSignal) {
+			return
+		}
+
 		if err := kong.Response.SetHeader("X-Cache-Status", "Bypass"); err != nil {
 			logger.Error().Err(err).Msg("Setting header `X-Cache-Status` failed")
 			return
@@ -731,12 +1115,22 @@ defer conf.Close() //nolint directives: gosimple
 		logger.Error().Err(err).Msg("Getting response headers failed")
 		return
 	}
+	conf.removeTagHeader(headers)
 	if conf.isDebug() {
 		for k, v := range headers {
 			logger.Debug().Msgf("Response header: %s: %s", k, v)
 		}
 	}
 
+	varyHeaders, varyWildcard := conf.responseVary(headers)
+	if varyWildcard {
+		logger.Debug().Msg("Response varies on everything")
+		if err := kong.Response.SetHeader("X-Cache-Status", "Bypass"); err != nil {
+			logger.Error().Err(err).Msg("Setting header `X-Cache-Status` failed")
+		}
+		return
+	}
+
 	rawBody, err := serviceResponseRawBody(kong)
 	if err != nil {
 		logger.Error().Err(err).Msg("Getting response body has failed")
@@ -755,8 +1149,25 @@ defer conf.Close() //nolint directives: gosimple
 	now := time.Now()
 	secs := now.Unix()
 
-	//	proxy_cache.res_headers = resp_get_headers(0, true)
-	//	proxy_cache.res_ttl = conf.cache_control and resource_ttl(cc) or conf.cache_ttl
+	cacheTTL := cacheSignal.CacheTTL
+	staleWhileRevalidate := conf.StaleWhileRevalidate
+	staleIfError := conf.StaleIfError
+	if conf.CacheControl {
+		resCC := conf.responseCacheControl(kong)
+		cacheTTL = conf.responseTTL(kong, resCC)
+		if secs, ok := resCC.seconds("stale-while-revalidate"); ok {
+			staleWhileRevalidate = int(secs)
+		}
+		if secs, ok := resCC.seconds("stale-if-error"); ok {
+			staleIfError = int(secs)
+		}
+		// must-revalidate 인 응답은 stale 한 상태로 응답하면 안 된다
+		if resCC.has("must-revalidate") || resCC.has("proxy-revalidate") {
+			staleWhileRevalidate = 0
+			staleIfError = 0
+		}
+	}
+
 	_, err = GetPluginAny(kong, "reqBody")
 	if err != nil {
 		logger.Error().Err(err).Msgf("Failed to get reqBody from plugin context")
@@ -769,30 +1180,52 @@ defer conf.Close() //nolint directives: gosimple
 		Body:      rawBody,
 		BodyLen:   len(rawBody),
 		Timestamp: secs,
-		TTL:       int64(conf.CacheTTL),
+		TTL:       int64(cacheTTL),
 		Version:   conf.CacheVersion,
 		//ReqBody: reqBody.([]byte),
+		StaleWhileRevalidate: int64(staleWhileRevalidate),
+		StaleIfError:         int64(staleIfError),
+		StorageTTL:           int64(cacheSignal.StorageTTL),
 	}
-	validate := validator.New()
-	if err := validate.Struct(conf); err != nil {
+	if err := conf.validateConfig(); err != nil {
 		logger.Error().Err(err).Msg("Cache value validation failed")
 		//validationErrors := err.(validator.ValidationErrors)
 		return
 	}
 	logger.Debug().Msgf("cacheValue: %+v", cacheValue)
 
-	_, marshal, err := conf.newCacheManager(int(cacheValue.TTL))
+	storageTTL := cacheValue.storageTTL()
+	_, marshal, err := conf.newCacheManager(storageTTL)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to create cache manager")
 		return
 	}
 
 	cacheKeyID := cacheSignal.CacheKeyID
-	if err := marshal.Set(context.Background(), cacheKeyID, cacheValue, lib_store.WithExpiration(time.Duration(cacheValue.TTL)*time.Second)); err != nil {
+
+	// Vary 헤더가 바뀌었다면 기록만 하고 저장하지 않는다. 이 응답은 새 Vary 헤더를 반영하지 않은 키로 찾은 것이다
+	if cacheSignal.BaseKeyID != "" && !slices.Equal(varyHeaders, cacheSignal.VaryHeaders) {
+		if err := conf.storeVaryIndex(marshal, cacheSignal.BaseKeyID, varyHeaders, storageTTL); err != nil {
+			logger.Error().Err(err).Msg("Failed to store vary headers")
+			return
+		}
+		logger.Debug().Msgf("Vary headers of '%s' are changed: %v -> %v", cacheSignal.BaseKeyID, cacheSignal.VaryHeaders, varyHeaders)
+		return
+	}
+
+	if err := marshal.Set(context.Background(), cacheKeyID, cacheValue, lib_store.WithExpiration(time.Duration(storageTTL)*time.Second)); err != nil {
 		logger.Error().Err(err).Msg("Cache set failed")
 		return
 	}
 	logger.Debug().Msgf("Cache set: %s", cacheKeyID)
+	stored = cacheValue
+	conf.indexCacheKey(cacheSignal, tags, storageTTL)
+
+	if cacheSignal.Revalidate {
+		if err := kong.Response.SetHeader("X-Cache-Status", "Revalidated"); err != nil {
+			logger.Error().Err(err).Msg("Setting header `X-Cache-Status` failed")
+		}
+	}
 }
 
 func (conf *Config) cacheableResponse(kong *pdk.PDK) bool {
@@ -836,21 +1269,33 @@ func (conf *Config) cacheableResponseContentType(kong *pdk.PDK) bool {
 	if contentType == "" {
 		return false
 	}
+
+	matched := false
 	for _, ct := range conf.ContentTypes {
 		if strings.EqualFold(contentType, ct) {
-			return true
+			matched = true
+			break
 		}
 	}
+	if !matched {
+		conf.logger.Debug().Msgf("Content type %s is not cacheable", contentType)
+		return false
+	}
+
+	if !conf.CacheControl {
+		return true
+	}
 
-	// TODO cache control은 나중에 구현하자
-	//if conf.cache_control and (cc["private"] or cc["no-store"] or cc["no-cache"])
-	//then
-	//	return false
-	//end
-	//
-	//if conf.cache_control and resource_ttl(cc) <= 0 then
-	//	return false
-	//end
+	resCC := conf.responseCacheControl(kong)
+	if resCC.has("private") || resCC.has("no-store") || resCC.has("no-cache") {
+		conf.logger.Debug().Msg("Response is not cacheable by Cache-Control")
+		return false
+	}
+
+	if conf.responseTTL(kong, resCC) <= 0 {
+		conf.logger.Debug().Msg("Response has no freshness lifetime")
+		return false
+	}
 
 	return true
 }
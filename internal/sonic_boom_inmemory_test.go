@@ -2,22 +2,62 @@ package internal
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/Kong/go-pdk/server/kong_plugin_protocol"
+	"github.com/dgraph-io/ristretto"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func newInMemoryConfigForTest() *Config {
 	cfg := configDefault()
 	cfg.Strategy = "in-memory"
-	cfg.InMemory.MaxCost = 1024
+	// ristretto 는 항목마다 내부 비용을 더하므로, 테스트끼리 공유하는 캐시가 가득 차서 새 키를 거절하지 않도록 넉넉히 둔다
+	cfg.InMemory.MaxCost = 1 << 20
 	cfg.InMemory.NumCounters = 1024
 	cfg.InMemory.BufferItems = 64
 	return cfg
 }
 
+// inMemoryTestSeq 는 isolateInMemoryForTest 가 테스트마다 다른 InMemory 설정값을 만들도록 센다
+var inMemoryTestSeq atomic.Int32
+
+// isolateInMemoryForTest 는 cfg 가 다른 테스트나 같은 테스트의 다른 실행과 나누지 않는 ristretto 캐시와 인덱스를 쓰게 한다.
+// 테스트가 끝나면 캐시와 인덱스, cfg 의 configRuntime 을 치운다.
+func isolateInMemoryForTest(t *testing.T, cfg *Config) {
+	cfg.InMemory.NumCounters += int(inMemoryTestSeq.Add(1))
+	inMemory := cfg.InMemory
+
+	t.Cleanup(func() {
+		configRuntimes.mu.Lock()
+		rt := configRuntimes.byConf[cfg]
+		delete(configRuntimes.byConf, cfg)
+		maps.DeleteFunc(configRuntimes.byHash, func(_ uint64, r *configRuntime) bool { return r == rt })
+		configRuntimes.mu.Unlock()
+		if rt != nil {
+			_ = rt.conf.Close()
+		}
+
+		if client, ok := ristrettoClients.LoadAndDelete(inMemory); ok {
+			client.(*ristretto.Cache).Close()
+		}
+		memoryIndexes.Delete(inMemory)
+	})
+}
+
 func cloneInMemoryConfig(c *Config) *Config {
 	n := *c
 	n.InMemory = c.InMemory
@@ -178,3 +218,508 @@ func Test_Unknown_Strategy_Error(t *testing.T) {
 	require.Nil(t, cm)
 	require.Nil(t, m)
 }
+
+// accessLookupSteps 는 Access 가 캐시를 조회하기 직전까지 호출하는 PDK 단계들이다.
+// cache_control 모드에서 인증 헤더가 없는 GET 요청을 가정한다.
+func accessLookupSteps(path string, cacheControl string) []bridgetest.MockStep {
+	steps := []bridgetest.MockStep{
+		{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+		{Method: "kong.request.get_path", Ret: bridge.WrapString(path)},
+	}
+	if otelEnabled {
+		steps = append(steps, bridgetest.MockStep{Method: "kong.ctx.shared.set"})
+	}
+	return append(steps,
+		bridgetest.MockStep{Method: "kong.request.get_header", Args: bridge.WrapString("Cache-Control"), Ret: bridge.WrapString(cacheControl)},
+		bridgetest.MockStep{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+		bridgetest.MockStep{Method: "kong.request.get_header", Args: bridge.WrapString("Authorization"), Ret: bridge.WrapString("")},
+		bridgetest.MockStep{Method: "kong.request.get_raw_body"},
+		bridgetest.MockStep{Method: "kong.client.get_consumer", Ret: &kong_plugin_protocol.Consumer{Id: "001"}},
+		bridgetest.MockStep{Method: "kong.router.get_service", Ret: &kong_plugin_protocol.Service{Id: "003:004"}},
+		bridgetest.MockStep{Method: "kong.router.get_route", Ret: &kong_plugin_protocol.Route{Id: "001:002"}},
+		bridgetest.MockStep{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+		bridgetest.MockStep{Method: "kong.request.get_path", Ret: bridge.WrapString(path)},
+		bridgetest.MockStep{Method: "kong.request.get_query", Args: &kong_plugin_protocol.Int{V: 1000}},
+		bridgetest.MockStep{Method: "kong.response.set_header"},
+		bridgetest.MockStep{Method: "kong.request.get_header", Args: bridge.WrapString(revalidateHeader), Ret: bridge.WrapString("")},
+	)
+}
+
+func Test_Access_InMemory_Miss(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		cacheControl string
+		wantSteps    []bridgetest.MockStep
+	}{
+		{
+			name: "miss is proxied to upstream",
+			path: "/access/miss",
+			wantSteps: []bridgetest.MockStep{
+				{Method: "kong.ctx.shared.set"},
+				{Method: "kong.ctx.shared.set"},
+				{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Miss")}},
+			},
+		},
+		{
+			name:         "only-if-cached miss returns 504",
+			path:         "/access/only-if-cached",
+			cacheControl: "only-if-cached",
+			wantSteps: []bridgetest.MockStep{
+				{Method: "kong.response.exit", Args: &kong_plugin_protocol.ExitArgs{Status: http.StatusGatewayTimeout}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newInMemoryConfigForTest()
+			cfg.CacheControl = true
+			cfg.CacheTTL = 60
+
+			kong, recorder := mockPdkSteps(t, append(accessLookupSteps(tt.path, tt.cacheControl), tt.wantSteps...))
+			cfg.Access(kong)
+			recorder.assertConsumed()
+		})
+	}
+}
+
+// accessMissSteps 는 캐시 미스 이후 Access 가 호출하는 PDK 단계들이다.
+var accessMissSteps = []bridgetest.MockStep{
+	{Method: "kong.ctx.shared.set"},
+	{Method: "kong.ctx.shared.set"},
+	{Method: "kong.response.set_header"},
+}
+
+// cacheKeyForTest 는 Access 를 한 번 실행해 요청의 캐시 키를 알아낸다.
+func cacheKeyForTest(t *testing.T, cfg *Config, path string) string {
+	kong, recorder := mockPdkSteps(t, append(accessLookupSteps(path, ""), accessMissSteps...))
+	cfg.Access(kong)
+	recorder.assertConsumed()
+
+	cacheKeyID := recorder.responseHeader("X-Cache-Key")
+	require.NotEmpty(t, cacheKeyID)
+	return cacheKeyID
+}
+
+// seedInMemory 는 캐시 값을 저장하고 ristretto 의 비동기 쓰기가 끝날 때까지 기다린다.
+func seedInMemory(t *testing.T, cfg *Config, cacheKeyID string, value *CacheValue) {
+	_, marshal, err := cfg.newCacheManager(value.storageTTL())
+	require.NoError(t, err)
+	require.NoError(t, marshal.Set(context.Background(), cacheKeyID, value))
+	require.Eventually(t, func() bool {
+		_, err := marshal.Get(context.Background(), cacheKeyID, new(CacheValue))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+// accessHitSteps 는 캐시된 값으로 응답할 때 Access 가 호출하는 PDK 단계들이다.
+func accessHitSteps(t *testing.T, requestHeaders map[string][]string) []bridgetest.MockStep {
+	headers, err := bridge.WrapHeaders(requestHeaders)
+	require.NoError(t, err)
+
+	return []bridgetest.MockStep{
+		{Method: "kong.nginx.set_ctx"},
+		{Method: "kong.request.get_headers", Ret: headers},
+		{Method: "kong.response.exit"},
+	}
+}
+
+func Test_Access_InMemory_StaleWhileRevalidate(t *testing.T) {
+	revalidated := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revalidated <- r
+		w.Header().Set("X-Cache-Status", "Revalidated")
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	cfg := newInMemoryConfigForTest()
+	isolateInMemoryForTest(t, cfg)
+	cfg.CacheControl = true
+	cfg.CacheTTL = 60
+	cfg.StaleWhileRevalidate = 30
+
+	path := "/access/stale-while-revalidate"
+	cacheKeyID := cacheKeyForTest(t, cfg, path)
+	t.Cleanup(func() { revalidating.Delete(cacheKeyID) })
+	seedInMemory(t, cfg, cacheKeyID, &CacheValue{
+		Status:               200,
+		Headers:              map[string][]string{"Content-Type": {"application/json"}},
+		Body:                 []byte(`{"stale":true}`),
+		BodyLen:              14,
+		Timestamp:            time.Now().Unix() - 70,
+		TTL:                  60,
+		Version:              Version,
+		StaleWhileRevalidate: 30,
+	})
+
+	headers, err := bridge.WrapHeaders(map[string][]string{"host": {"example.com"}})
+	require.NoError(t, err)
+	steps := append(accessLookupSteps(path, ""),
+		bridgetest.MockStep{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+		bridgetest.MockStep{Method: "kong.request.get_scheme", Ret: bridge.WrapString("http")},
+		bridgetest.MockStep{Method: "kong.nginx.get_var", Args: bridge.WrapString("server_addr"), Ret: bridge.WrapString(serverURL.Hostname())},
+		bridgetest.MockStep{Method: "kong.nginx.get_var", Args: bridge.WrapString("server_port"), Ret: bridge.WrapString(serverURL.Port())},
+		bridgetest.MockStep{Method: "kong.request.get_path_with_query", Ret: bridge.WrapString(path)},
+		bridgetest.MockStep{Method: "kong.request.get_headers", Ret: headers},
+	)
+	steps = append(steps, accessHitSteps(t, nil)...)
+	kong, recorder := mockPdkSteps(t, steps)
+	cfg.Access(kong)
+	recorder.assertConsumed()
+
+	// stale 한 값을 바로 응답한다
+	exit := recorder.exitArgs()
+	assert.Equal(t, int32(200), exit.Status)
+	assert.Equal(t, `{"stale":true}`, string(exit.Body))
+	assert.Equal(t, []string{"Stale"}, bridge.UnwrapHeaders(exit.Headers)["X-Cache-Status"])
+
+	// 백그라운드 갱신 요청이 Kong 으로 다시 들어온다
+	select {
+	case r := <-revalidated:
+		assert.Equal(t, path, r.URL.Path)
+		assert.Equal(t, "example.com", r.Host)
+		assert.Equal(t, revalidateToken, r.Header.Get(revalidateHeader))
+	case <-time.After(time.Second):
+		t.Fatal("revalidate request is not sent")
+	}
+
+	// 백그라운드 갱신이 끝날 때까지 기다린다
+	require.Eventually(t, func() bool {
+		_, ok := revalidating.Load(cacheKeyID)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_Access_InMemory_RevalidateRequest(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	cfg.CacheControl = true
+	cfg.CacheTTL = 60
+
+	path := "/access/revalidate-request"
+	steps := accessLookupSteps(path, "")
+	steps[len(steps)-1].Ret = bridge.WrapString(revalidateToken)
+	steps = append(steps,
+		bridgetest.MockStep{Method: "kong.service.request.clear_header", Args: bridge.WrapString(revalidateHeader)},
+		bridgetest.MockStep{Method: "kong.ctx.shared.set"},
+		bridgetest.MockStep{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Refresh")}},
+	)
+
+	kong, recorder := mockPdkSteps(t, steps)
+	cfg.Access(kong)
+	recorder.assertConsumed()
+}
+
+// disableOtelForTest 는 Response 가 span context 없이 동작하도록 OpenTelemetry 를 끈다.
+func disableOtelForTest(t *testing.T) {
+	enabled := otelEnabled
+	otelEnabled = false
+	t.Cleanup(func() { otelEnabled = enabled })
+}
+
+// responseSignalSteps 는 Response 가 업스트림 상태 코드와 cacheSignal 을 읽는 PDK 단계들이다.
+func responseSignalSteps(t *testing.T, status int, signal CacheSignal) []bridgetest.MockStep {
+	data, err := json.Marshal(signal)
+	require.NoError(t, err)
+
+	return []bridgetest.MockStep{
+		{Method: "kong.response.get_status", Ret: &kong_plugin_protocol.Int{V: int32(status)}},
+		{Method: "kong.ctx.shared.get", Args: bridge.WrapString("cacheSignal"), Ret: structpb.NewStringValue(base64.StdEncoding.EncodeToString(data))},
+	}
+}
+
+func Test_Response_InMemory_StaleIfError(t *testing.T) {
+	disableOtelForTest(t)
+
+	tests := []struct {
+		name       string
+		cacheKeyID string
+		age        int64
+		purged     bool
+		wantStale  bool
+		wantStatus string
+	}{
+		{
+			name:       "stale value within stale-if-error is served",
+			cacheKeyID: "stale-if-error-within",
+			age:        90,
+			wantStale:  true,
+			wantStatus: "Stale",
+		},
+		{
+			name:       "soft purged value is served as Purged",
+			cacheKeyID: "stale-if-error-purged",
+			age:        10,
+			purged:     true,
+			wantStale:  true,
+			wantStatus: "Purged",
+		},
+		{
+			name:       "stale value beyond stale-if-error is not served",
+			cacheKeyID: "stale-if-error-beyond",
+			age:        130,
+			wantStale:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newInMemoryConfigForTest()
+			cfg.CacheTTL = 60
+			cfg.StaleIfError = 60
+			cfg.CacheVersion = Version
+
+			value := &CacheValue{
+				Status:       200,
+				Headers:      map[string][]string{"Content-Type": {"application/json"}},
+				Body:         []byte(`{"stale":true}`),
+				BodyLen:      14,
+				Timestamp:    time.Now().Unix() - tt.age,
+				TTL:          60,
+				Version:      Version,
+				StaleIfError: 60,
+			}
+			if tt.purged {
+				value.Purged = time.Now().Unix()
+			}
+			seedInMemory(t, cfg, tt.cacheKeyID, value)
+
+			steps := append(responseSignalSteps(t, http.StatusBadGateway, CacheSignal{CacheKeyID: tt.cacheKeyID, CacheTTL: 60}),
+				bridgetest.MockStep{Method: "kong.response.get_status", Ret: &kong_plugin_protocol.Int{V: http.StatusBadGateway}},
+			)
+			if tt.wantStale {
+				steps = append(steps, bridgetest.MockStep{Method: "kong.response.exit"})
+			} else {
+				steps = append(steps, bridgetest.MockStep{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Bypass")}})
+			}
+
+			kong, recorder := mockPdkSteps(t, steps)
+			cfg.Response(kong)
+			recorder.assertConsumed()
+
+			if tt.wantStale {
+				exit := recorder.exitArgs()
+				assert.Equal(t, int32(200), exit.Status)
+				assert.Equal(t, `{"stale":true}`, string(exit.Body))
+				headers := bridge.UnwrapHeaders(exit.Headers)
+				assert.Equal(t, []string{tt.wantStatus}, headers["X-Cache-Status"])
+				assert.Equal(t, []string{`111 - "Revalidation Failed"`}, headers["Warning"])
+				assert.Equal(t, []string{"sonic-boom; hit; fwd=stale; fwd-status=502"}, headers["Cache-Status"])
+			}
+		})
+	}
+}
+
+func Test_Access_InMemory_Conditional(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		requestHeaders map[string][]string
+		wantStatus     int32
+		wantBody       string
+	}{
+		{
+			name:        "matching If-None-Match gets 304",
+			path:           "/access/not-modified",
+			requestHeaders: map[string][]string{"if-none-match": {`"v1"`}},
+			wantStatus:     http.StatusNotModified,
+			wantBody:       "",
+		},
+		{
+			name:        "different If-None-Match gets the body",
+			path:           "/access/modified",
+			requestHeaders: map[string][]string{"if-none-match": {`"v0"`}},
+			wantStatus:     http.StatusOK,
+			wantBody:       `{"etag":true}`,
+		},
+		{
+			name:           "If-Modified-Since after the stored time gets 304",
+			path:           "/access/not-modified-since",
+			requestHeaders: map[string][]string{"if-modified-since": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+			wantStatus:     http.StatusNotModified,
+			wantBody:       "",
+		},
+		{
+			name:           "failed If-Match gets 412",
+			path:           "/access/precondition-failed",
+			requestHeaders: map[string][]string{"if-match": {`"v0"`}},
+			wantStatus:     http.StatusPreconditionFailed,
+			wantBody:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newInMemoryConfigForTest()
+			isolateInMemoryForTest(t, cfg)
+			cfg.CacheControl = true
+			cfg.CacheTTL = 60
+			cfg.CacheVersion = Version
+
+			path := tt.path
+			cacheKeyID := cacheKeyForTest(t, cfg, path)
+			seedInMemory(t, cfg, cacheKeyID, &CacheValue{
+				Status:    200,
+				Headers:   map[string][]string{"Content-Type": {"application/json"}, "ETag": {`"v1"`}},
+				Body:      []byte(`{"etag":true}`),
+				BodyLen:   13,
+				Timestamp: time.Now().Unix(),
+				TTL:       60,
+				Version:   Version,
+			})
+
+			steps := append(accessLookupSteps(path, ""), accessHitSteps(t, tt.requestHeaders)...)
+			kong, recorder := mockPdkSteps(t, steps)
+			cfg.Access(kong)
+			recorder.assertConsumed()
+
+			exit := recorder.exitArgs()
+			assert.Equal(t, tt.wantStatus, exit.Status)
+			assert.Equal(t, tt.wantBody, string(exit.Body))
+			headers := bridge.UnwrapHeaders(exit.Headers)
+			assert.Equal(t, []string{"Hit"}, headers["X-Cache-Status"])
+			if tt.wantStatus != http.StatusPreconditionFailed {
+				assert.Equal(t, []string{`"v1"`}, headers["ETag"])
+			}
+		})
+	}
+}
+
+func Test_Access_InMemory_StaleConditionalRefresh(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	isolateInMemoryForTest(t, cfg)
+	cfg.CacheTTL = 60
+	cfg.CacheVersion = Version
+	cfg.CacheControl = true
+
+	path := "/access/stale-conditional"
+	cacheKeyID := cacheKeyForTest(t, cfg, path)
+	seedInMemory(t, cfg, cacheKeyID, &CacheValue{
+		Status:     200,
+		Headers:    map[string][]string{"Content-Type": {"application/json"}, "ETag": {`"v1"`}},
+		Body:       []byte(`{"stale":true}`),
+		BodyLen:    14,
+		Timestamp:  time.Now().Unix() - 70,
+		TTL:        60,
+		Version:    Version,
+		StorageTTL: 600,
+	})
+
+	// 업스트림 요청에 ETag 로 If-None-Match 를 붙이고, 클라이언트의 If-Modified-Since 는 지운다
+	steps := append(accessLookupSteps(path, ""),
+		bridgetest.MockStep{Method: "kong.service.request.set_header", Args: &kong_plugin_protocol.KV{K: "If-None-Match", V: structpb.NewStringValue(`"v1"`)}},
+		bridgetest.MockStep{Method: "kong.service.request.clear_header", Args: bridge.WrapString("If-Modified-Since")},
+		bridgetest.MockStep{Method: "kong.ctx.shared.set"},
+		bridgetest.MockStep{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Refresh")}},
+	)
+	kong, recorder := mockPdkSteps(t, steps)
+	cfg.Access(kong)
+	recorder.assertConsumed()
+}
+
+// cache_ttl 이 0 인 값은 스토어에 남아도 저장한 그 초가 지나면 갱신한다
+func Test_Access_InMemory_ZeroTTLRefresh(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	isolateInMemoryForTest(t, cfg)
+	cfg.CacheControl = true
+	cfg.CacheTTL = 0
+	cfg.CacheVersion = Version
+
+	path := "/access/zero-ttl"
+	cacheKeyID := cacheKeyForTest(t, cfg, path)
+	seedInMemory(t, cfg, cacheKeyID, &CacheValue{
+		Status:    200,
+		Headers:   map[string][]string{"Content-Type": {"application/json"}},
+		Body:      []byte(`{"zero":true}`),
+		BodyLen:   13,
+		Timestamp: time.Now().Unix() - 10,
+		TTL:       0,
+		Version:   Version,
+	})
+
+	steps := append(accessLookupSteps(path, ""),
+		bridgetest.MockStep{Method: "kong.ctx.shared.set"},
+		bridgetest.MockStep{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Refresh")}},
+	)
+	kong, recorder := mockPdkSteps(t, steps)
+	cfg.Access(kong)
+	recorder.assertConsumed()
+}
+
+func Test_Response_InMemory_NotModified(t *testing.T) {
+	disableOtelForTest(t)
+
+	tests := []struct {
+		name           string
+		cacheKeyID     string
+		requestHeaders map[string][]string
+		wantStatus     int32
+		wantBody       string
+	}{
+		{
+			name:       "stored body is served",
+			cacheKeyID: "not-modified-body",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"stale":true}`,
+		},
+		{
+			name:           "client validator still gets 304",
+			cacheKeyID:     "not-modified-client-etag",
+			requestHeaders: map[string][]string{"if-none-match": {`"v1"`}},
+			wantStatus:     http.StatusNotModified,
+			wantBody:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newInMemoryConfigForTest()
+			cfg.CacheTTL = 60
+			cfg.CacheVersion = Version
+			cfg.CacheControl = true
+
+			storedAt := time.Now().Unix() - 70
+			seedInMemory(t, cfg, tt.cacheKeyID, &CacheValue{
+				Status:     200,
+				Headers:    map[string][]string{"Content-Type": {"application/json"}, "ETag": {`"v1"`}, "Cache-Control": {"max-age=60"}},
+				Body:       []byte(`{"stale":true}`),
+				BodyLen:    14,
+				Timestamp:  storedAt,
+				TTL:        60,
+				Version:    Version,
+				StorageTTL: 600,
+			})
+
+			responseHeaders, err := bridge.WrapHeaders(map[string][]string{"etag": {`"v1"`}, "cache-control": {"max-age=120"}})
+			require.NoError(t, err)
+			requestHeaders, err := bridge.WrapHeaders(tt.requestHeaders)
+			require.NoError(t, err)
+
+			signal := CacheSignal{CacheKeyID: tt.cacheKeyID, CacheTTL: 60, Conditional: true}
+			steps := append(responseSignalSteps(t, http.StatusNotModified, signal),
+				bridgetest.MockStep{Method: "kong.response.get_headers", Ret: responseHeaders},
+				bridgetest.MockStep{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+				bridgetest.MockStep{Method: "kong.request.get_headers", Ret: requestHeaders},
+				bridgetest.MockStep{Method: "kong.response.exit"},
+			)
+			kong, recorder := mockPdkSteps(t, steps)
+			cfg.Response(kong)
+			recorder.assertConsumed()
+
+			exit := recorder.exitArgs()
+			assert.Equal(t, tt.wantStatus, exit.Status)
+			assert.Equal(t, tt.wantBody, string(exit.Body))
+			assert.Equal(t, []string{"Revalidated"}, bridge.UnwrapHeaders(exit.Headers)["X-Cache-Status"])
+
+			// 본문은 그대로 두고 Timestamp 와 TTL 을 갱신한다
+			_, marshal, err := cfg.newCacheManager(60)
+			require.NoError(t, err)
+			require.Eventually(t, func() bool {
+				v := getCacheValue(context.Background(), marshal, tt.cacheKeyID)
+				return v != nil && v.Timestamp > storedAt
+			}, time.Second, 10*time.Millisecond)
+			v := getCacheValue(context.Background(), marshal, tt.cacheKeyID)
+			assert.Equal(t, []byte(`{"stale":true}`), v.Body)
+			assert.Equal(t, int64(120), v.TTL)
+			assert.Equal(t, []string{"max-age=120"}, v.Headers["Cache-Control"])
+		})
+	}
+}
@@ -414,6 +414,9 @@ func configDefault() *Config {
 		CacheableBodyMaxSize: 0,
 		CacheVersion:         "",
 		Strategy:             "redis",
+		IdempotencyTTL:       86400,
+		IdempotencyLockMs:    60000,
+		InvalidationRedis:    "redis",
 
 		InMemory: InMemoryConfig{
 			MaxCost:     1000000,
@@ -421,6 +424,11 @@ func configDefault() *Config {
 			BufferItems: 64,
 		},
 
+		Tiered: TieredConfig{
+			L2:    "redis",
+			L1TTL: 5,
+		},
+
 		Redis: RedisConfig{
 			// Host:              "localhost",
 			Port:              6379,
@@ -449,6 +457,21 @@ func configDefault() *Config {
 			IdleTimeout:       1,
 		},
 
+		RedisSentinel: RedisSentinelConfig{
+			// MasterName:        "mymaster",
+			// SentinelAddrs:     []string{"localhost:26379"},
+			DBNumber:          0,
+			PoolSize:          10,
+			MaxRetries:        3,
+			MinRetryBackoffMs: 8,
+			MaxRetryBackoffMs: 512,
+			DialTimeout:       5,
+			ReadTimeout:       3,
+			WriteTimeout:      3,
+			PoolTimeout:       5,
+			IdleTimeout:       1,
+		},
+
 		LogConf: LogConfig{
 			LogLevel:              "info",
 			ConsoleLoggingEnabled: true,
@@ -946,9 +969,9 @@ func TestConfig_filtered(t *testing.T) {
 				LogConf:              tt.fields.LogConf,
 				logger:               tt.fields.logger,
 			}
-			got, got1 := conf.filtered(tt.args.kong)
+			got, got1 := conf.filtered(tt.args.kong, "")
 			assert.Equalf(t, tt.want, got, "filtered(%v)", tt.args.kong)
-			assert.Equalf(t, tt.want1, got1, "filtered(%v)", tt.args.kong)
+			assert.Equalf(t, tt.want1, got1.CacheTTL, "filtered(%v)", tt.args.kong)
 		})
 	}
 }
@@ -970,7 +993,8 @@ func TestConfig_cacheableRequest(t *testing.T) {
 		logger               *Logger
 	}
 	type args struct {
-		kong *pdk.PDK
+		kong  *pdk.PDK
+		reqCC cacheControl
 	}
 	tests := []struct {
 		name   string
@@ -1024,6 +1048,71 @@ func TestConfig_cacheableRequest(t *testing.T) {
 			want:  true,
 			want1: 1,
 		},
+		{
+			name: "test cache control no-store",
+			fields: fields{
+				logger:         defaultLogger(),
+				RequestMethods: []string{"GET", "HEAD"},
+				Filters:        []Filter{},
+				CacheTTL:       2,
+				CacheControl:   true,
+			},
+			args: args{
+				kong: &pdk.PDK{
+					Request: mockRequest(t, []bridgetest.MockStep{
+						{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+					}),
+					Log: mockLogDefault(t),
+				},
+				reqCC: parseCacheControl("no-store"),
+			},
+			want:  false,
+			want1: 0,
+		},
+		{
+			name: "test cache control with authorization",
+			fields: fields{
+				logger:         defaultLogger(),
+				RequestMethods: []string{"GET", "HEAD"},
+				Filters:        []Filter{},
+				CacheTTL:       2,
+				CacheControl:   true,
+			},
+			args: args{
+				kong: &pdk.PDK{
+					Request: mockRequest(t, []bridgetest.MockStep{
+						{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+						{Method: "kong.request.get_header", Args: bridge.WrapString("Authorization"), Ret: bridge.WrapString("Basic a29yZWFpb6aaaaa29yZWFpbnYwMjI4")},
+					}),
+					Log: mockLogDefault(t),
+				},
+				reqCC: parseCacheControl("max-age=10"),
+			},
+			want:  false,
+			want1: 0,
+		},
+		{
+			name: "test cache control without authorization",
+			fields: fields{
+				logger:         defaultLogger(),
+				RequestMethods: []string{"GET", "HEAD"},
+				Filters:        []Filter{},
+				CacheTTL:       2,
+				CacheControl:   true,
+			},
+			args: args{
+				kong: &pdk.PDK{
+					Request: mockRequest(t, []bridgetest.MockStep{
+						{Method: "kong.request.get_method", Ret: bridge.WrapString("GET")},
+						{Method: "kong.request.get_header", Args: bridge.WrapString("Authorization"), Ret: bridge.WrapString("")},
+					}),
+					Log: mockLogDefault(t),
+				},
+				reqCC: parseCacheControl("max-age=10"),
+			},
+			want:  true,
+			want1: 2,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1042,9 +1131,32 @@ func TestConfig_cacheableRequest(t *testing.T) {
 				LogConf:              tt.fields.LogConf,
 				logger:               tt.fields.logger,
 			}
-			got, got1 := conf.cacheableRequest(tt.args.kong)
+			got, got1 := conf.cacheableRequest(tt.args.kong, tt.args.reqCC, "")
 			assert.Equalf(t, tt.want, got, "cacheableRequest(%v)", tt.args.kong)
-			assert.Equalf(t, tt.want1, got1, "cacheableRequest(%v)", tt.args.kong)
+			assert.Equalf(t, tt.want1, got1.CacheTTL, "cacheableRequest(%v)", tt.args.kong)
 		})
 	}
 }
+
+func TestConfig_Init_FilterTTL(t *testing.T) {
+	conf := configDefault()
+	conf.CacheTTL = 10
+	conf.StorageTTL = 100
+	conf.Filters = []Filter{
+		{Name: "inherit"},
+		{Name: "override", CacheTTL: 1, StorageTTL: 5},
+	}
+	conf.Init()
+	defer conf.Close() //nolint directives: gosimple
+
+	assert.Equal(t, 10, conf.Filters[0].CacheTTL)
+	assert.Equal(t, 100, conf.Filters[0].StorageTTL)
+	assert.Equal(t, 1, conf.Filters[1].CacheTTL)
+	assert.Equal(t, 5, conf.Filters[1].StorageTTL)
+
+	conf.Filters = nil
+	ok, filter := conf.filtered(&pdk.PDK{}, "")
+	assert.True(t, ok)
+	normalizeQuery := false
+	assert.Equal(t, Filter{CacheTTL: 10, StorageTTL: 100, NormalizeQuery: &normalizeQuery}, filter)
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Kong/go-pdk"
+)
+
+// staleIfErrorEnabled 는 stale-if-error 가 쓰일 수 있는지 알려준다.
+// cache_control 모드에서는 응답의 stale-if-error 디렉티브로도 켜진다.
+func (conf *Config) staleIfErrorEnabled() bool {
+	return conf.StaleIfError > 0 || conf.CacheControl
+}
+
+// serveStaleIfError 는 업스트림이 5xx 로 응답했을 때 스토어에 남아 있는 값으로 대신 응답한다.
+// 대신 응답했다면 true 를 돌려준다.
+//
+// See https://www.rfc-editor.org/rfc/rfc5861#section-4
+func (conf *Config) serveStaleIfError(kong *pdk.PDK, httpStatus int, signal CacheSignal) bool {
+	if httpStatus < http.StatusInternalServerError || !conf.staleIfErrorEnabled() {
+		return false
+	}
+
+	logger := conf.logger
+
+	_, marshal, err := conf.newCacheManager(signal.CacheTTL)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create cache manager")
+		return false
+	}
+
+	cached, err := marshal.Get(context.Background(), signal.CacheKeyID, new(CacheValue))
+	if cached == nil || err != nil {
+		logger.Debug().Err(err).Msgf("No stale value for cache key '%s'", signal.CacheKeyID)
+		return false
+	}
+
+	cacheValue := cached.(*CacheValue)
+	secs := time.Now().Unix()
+	if cacheValue.Version != conf.CacheVersion || !cacheValue.usableOnError(secs) {
+		logger.Debug().Msgf("Cache key '%s' is too stale to serve on error", signal.CacheKeyID)
+		return false
+	}
+
+	headers := cacheValue.responseHeaders(cacheValue.staleStatus("Stale"), secs)
+	headers["Warning"] = []string{`111 - "Revalidation Failed"`}
+	// See https://www.rfc-editor.org/rfc/rfc9211
+	headers["Cache-Status"] = []string{fmt.Sprintf("sonic-boom; hit; fwd=stale; fwd-status=%d", httpStatus)}
+
+	logger.Warn().Msgf("Upstream responded %d, serving stale cache key '%s'", httpStatus, signal.CacheKeyID)
+	kong.Response.Exit(cacheValue.Status, cacheValue.Body, headers)
+	return true
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/Kong/go-pdk"
+)
+
+// tagIndexPrefix 는 태그별로 캐시 키를 모아 두는 인덱스의 접두어다.
+const tagIndexPrefix = "sonic-boom:tag:"
+
+func tagIndexName(tag string) string {
+	return tagIndexPrefix + tag
+}
+
+// parseTags 는 태그 헤더 값을 태그로 나눈다.
+// Surrogate-Key 는 공백으로, Cache-Tag 는 쉼표로 나누므로 둘 다 구분자로 본다.
+func parseTags(values ...string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, tag := range strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		}) {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// responseTags 는 업스트림 응답의 태그 헤더를 읽고, 클라이언트에게 보내지 않도록 지운다.
+func (conf *Config) responseTags(kong *pdk.PDK) []string {
+	logger := conf.logger
+
+	if conf.TagHeader == "" {
+		return nil
+	}
+
+	headers, err := kong.Response.GetHeaders(1000)
+	if err != nil {
+		logger.Error().Err(err).Msg("Getting response headers failed")
+		return nil
+	}
+	var values []string
+	for k, v := range headers {
+		if strings.EqualFold(k, conf.TagHeader) {
+			values = append(values, v...)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	if err := kong.Response.ClearHeader(conf.TagHeader); err != nil {
+		logger.Error().Err(err).Msgf("Clearing header `%s` failed", conf.TagHeader)
+	}
+	return parseTags(values...)
+}
+
+// removeTagHeader 는 저장할 응답 헤더에서 태그 헤더를 뺀다. 캐시 히트에도 태그가 나가지 않도록 한다.
+func (conf *Config) removeTagHeader(headers map[string][]string) {
+	if conf.TagHeader == "" {
+		return
+	}
+	for k := range headers {
+		if strings.EqualFold(k, conf.TagHeader) {
+			delete(headers, k)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/Kong/go-pdk/server/kong_plugin_protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{name: "empty", values: []string{""}, want: nil},
+		{name: "surrogate key", values: []string{"product-42  products"}, want: []string{"product-42", "products"}},
+		{name: "cache tag", values: []string{"product-42,products, category-7"}, want: []string{"product-42", "products", "category-7"}},
+		{name: "multiple headers", values: []string{"product-42", "products product-42"}, want: []string{"product-42", "products"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseTags(tt.values...))
+		})
+	}
+}
+
+func Test_Response_InMemory_Tags(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.CacheTTL = 60
+	cfg.CacheVersion = Version
+	cfg.TagHeader = "Surrogate-Key"
+
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	storeSteps := func(signal CacheSignal, tags string) []bridgetest.MockStep {
+		headers := map[string][]string{"Content-Type": {"application/json"}, "Surrogate-Key": {tags}}
+		wrapped, err := bridge.WrapHeaders(headers)
+		require.NoError(t, err)
+
+		steps := responseStoreSteps(t, signal, headers, "{}")
+		return append([]bridgetest.MockStep{
+			steps[0],
+			{Method: "kong.response.get_headers", Ret: wrapped},
+			{Method: "kong.response.clear_header", Args: bridge.WrapString("Surrogate-Key")},
+		}, steps[1:]...)
+	}
+
+	stored := map[string]string{
+		"tag-product-42":         "product-42 products",
+		"tag-product-42-reviews": "product-42",
+		"tag-product-7":          "product-7 products",
+	}
+	for key, tags := range stored {
+		kong, recorder := mockPdkSteps(t, storeSteps(CacheSignal{CacheKeyID: key, CacheTTL: 60}, tags))
+		cfg.Response(kong)
+		recorder.assertConsumed()
+	}
+	require.Eventually(t, func() bool {
+		for key := range stored {
+			if getCacheValue(ctx, marshal, key) == nil {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	// 캐시 히트에도 태그 헤더가 나가지 않는다
+	cached := getCacheValue(ctx, marshal, "tag-product-42")
+	assert.NotContains(t, cached.Headers, "Surrogate-Key")
+	assert.Contains(t, cached.Headers, "Content-Type")
+
+	purged, err := cfg.purge(ctx, purgeRequest{Tag: "product-42"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Nil(t, getCacheValue(ctx, marshal, "tag-product-42"))
+	assert.Nil(t, getCacheValue(ctx, marshal, "tag-product-42-reviews"))
+	assert.NotNil(t, getCacheValue(ctx, marshal, "tag-product-7"))
+
+	// 이미 지운 캐시 키는 인덱스에 남아 있어도 세지 않는다
+	purged, err = cfg.purge(ctx, purgeRequest{Tag: "products"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	purged, err = cfg.purge(ctx, purgeRequest{Tag: "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged)
+	assert.Nil(t, getCacheValue(ctx, marshal, "tag-product-7"))
+}
+
+func Test_Response_InMemory_TagsStripped(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.TagHeader = "Cache-Tag"
+
+	// 캐시하지 않는 응답에서도 태그 헤더를 지운다
+	wrapped, err := bridge.WrapHeaders(map[string][]string{"cache-tag": {"product-42"}})
+	require.NoError(t, err)
+	kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+		{Method: "kong.response.get_status", Ret: &kong_plugin_protocol.Int{V: http.StatusOK}},
+		{Method: "kong.response.get_headers", Ret: wrapped},
+		{Method: "kong.response.clear_header", Args: bridge.WrapString("Cache-Tag")},
+		{Method: "kong.ctx.shared.get", Args: bridge.WrapString("cacheSignal"), Ret: structpb.NewNullValue()},
+	})
+	cfg.Response(kong)
+	recorder.assertConsumed()
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	lib_store "github.com/eko/gocache/lib/v4/store"
+)
+
+// TieredConfig 는 tiered 전략의 설정이다. L1 은 InMemory 설정의 ristretto 캐시, L2 는 Redis, RedisCluster, RedisSentinel 설정의 Redis 다.
+type TieredConfig struct {
+	L2 string `json:"l2" validate:"oneof=redis redis-cluster redis-sentinel" default:"redis"`
+	// L1TTL 은 L1 에 두는 최대 기간(초)이다. L2 에서 지워진 값도 다른 노드의 L1 에는 이 기간 동안 남을 수 있다
+	L1TTL int `json:"l1_ttl" validate:"gt=0" default:"5"`
+}
+
+// tieredCache 는 L1 을 먼저 읽고, 없으면 L2 에서 읽어 L1 을 채운다. 쓰기와 지우기는 두 계층에 모두 한다.
+// gocache 의 ChainCache 는 만들 때마다 고루틴을 띄우므로 요청마다 만들 수 없어 직접 구현한다.
+type tieredCache struct {
+	l1 lib_store.StoreInterface
+	l2 lib_store.StoreInterface
+	// expiration 은 옵션 없이 Set 할 때의 만료 기간이다
+	expiration time.Duration
+	l1TTL      time.Duration
+}
+
+func newTieredCache(l1 lib_store.StoreInterface, l2 lib_store.StoreInterface, expiration time.Duration, l1TTL time.Duration) *tieredCache {
+	return &tieredCache{l1: l1, l2: l2, expiration: expiration, l1TTL: l1TTL}
+}
+
+// l1Expiration 은 L2 의 만료 기간을 L1TTL 로 자른다. 0 이하는 만료가 없다는 뜻이다.
+func (c *tieredCache) l1Expiration(expiration time.Duration) time.Duration {
+	if expiration <= 0 || expiration > c.l1TTL {
+		return c.l1TTL
+	}
+	return expiration
+}
+
+func (c *tieredCache) Get(ctx context.Context, key any) (any, error) {
+	if value, err := c.l1.Get(ctx, key); err == nil {
+		return value, nil
+	}
+
+	value, ttl, err := c.l2.GetWithTTL(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	// L1 을 채우지 못해도 L2 의 값으로 응답한다
+	_ = c.l1.Set(ctx, key, value, lib_store.WithExpiration(c.l1Expiration(ttl)))
+	return value, nil
+}
+
+func (c *tieredCache) Set(ctx context.Context, key any, object any, options ...lib_store.Option) error {
+	opts := lib_store.ApplyOptionsWithDefault(&lib_store.Options{Expiration: c.expiration}, options...)
+	if err := c.l2.Set(ctx, key, object, lib_store.WithExpiration(opts.Expiration)); err != nil {
+		return err
+	}
+	// ristretto 는 붐빌 때 쓰기를 버릴 수 있다. L2 에는 저장했으므로 다음 읽기에서 L1 을 채운다
+	_ = c.l1.Set(ctx, key, object, lib_store.WithExpiration(c.l1Expiration(opts.Expiration)))
+	return nil
+}
+
+func (c *tieredCache) Delete(ctx context.Context, key any) error {
+	return errors.Join(c.l2.Delete(ctx, key), c.l1.Delete(ctx, key))
+}
+
+func (c *tieredCache) Invalidate(ctx context.Context, options ...lib_store.InvalidateOption) error {
+	return errors.Join(c.l2.Invalidate(ctx, options...), c.l1.Invalidate(ctx, options...))
+}
+
+func (c *tieredCache) Clear(ctx context.Context) error {
+	return errors.Join(c.l2.Clear(ctx), c.l1.Clear(ctx))
+}
+
+func (c *tieredCache) GetType() string {
+	return "tiered"
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredCache_l1Expiration(t *testing.T) {
+	c := newTieredCache(nil, nil, time.Minute, 5*time.Second)
+
+	tests := []struct {
+		name       string
+		expiration time.Duration
+		want       time.Duration
+	}{
+		{name: "shorter than l1 ttl", expiration: 2 * time.Second, want: 2 * time.Second},
+		{name: "longer than l1 ttl", expiration: time.Minute, want: 5 * time.Second},
+		{name: "no expiration", expiration: 0, want: 5 * time.Second},
+		{name: "redis ttl of persistent key", expiration: -1, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, c.l1Expiration(tt.expiration))
+		})
+	}
+}
+
+func newTieredConfigForTest(t *testing.T) (*Config, *miniredis.Miniredis) {
+	cfg, server := newRedisConfigForTest(t)
+	cfg.Strategy = "tiered"
+	return cfg, server
+}
+
+func TestTieredCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("write through", func(t *testing.T) {
+		cfg, server := newTieredConfigForTest(t)
+		_, marshal, err := cfg.newCacheManager(60)
+		require.NoError(t, err)
+		client, err := cfg.ristrettoClient()
+		require.NoError(t, err)
+
+		key := "tiered-write-through"
+		require.NoError(t, marshal.Set(ctx, key, &CacheValue{Status: 200, TTL: 60}))
+		client.Wait()
+
+		assert.True(t, server.Exists(key))
+		assert.Equal(t, 60*time.Second, server.TTL(key))
+		ttl, ok := client.GetTTL(key)
+		require.True(t, ok)
+		assert.LessOrEqual(t, ttl, 5*time.Second)
+
+		// L2 에서 사라져도 L1 의 값으로 응답한다
+		server.Del(key)
+		assert.NotNil(t, getCacheValue(ctx, marshal, key))
+
+		// 지우면 두 계층에서 모두 지운다
+		require.NoError(t, marshal.Set(ctx, key, &CacheValue{Status: 200, TTL: 60}))
+		client.Wait()
+		require.NoError(t, marshal.Delete(ctx, key))
+		assert.False(t, server.Exists(key))
+		assert.Nil(t, getCacheValue(ctx, marshal, key))
+	})
+
+	t.Run("fills l1 from l2", func(t *testing.T) {
+		cfg, server := newTieredConfigForTest(t)
+		_, marshal, err := cfg.newCacheManager(60)
+		require.NoError(t, err)
+		client, err := cfg.ristrettoClient()
+		require.NoError(t, err)
+
+		// 다른 노드가 L2 에만 저장한 값
+		redisCfg := *cfg
+		redisCfg.Strategy = "redis"
+		_, redisMarshal, err := redisCfg.newCacheManager(2)
+		require.NoError(t, err)
+
+		key := "tiered-fill"
+		// ristretto 캐시는 테스트 사이에 함께 쓴다
+		client.Del(key)
+		require.NoError(t, redisMarshal.Set(ctx, key, &CacheValue{Status: 203, TTL: 2}))
+		_, ok := client.Get(key)
+		require.False(t, ok)
+
+		value := getCacheValue(ctx, marshal, key)
+		require.NotNil(t, value)
+		assert.Equal(t, 203, value.Status)
+		client.Wait()
+
+		// L2 의 남은 기간이 L1TTL 보다 짧으면 남은 기간만 둔다
+		ttl, ok := client.GetTTL(key)
+		require.True(t, ok)
+		assert.LessOrEqual(t, ttl, 2*time.Second)
+
+		server.Del(key)
+		assert.NotNil(t, getCacheValue(ctx, marshal, key))
+	})
+}
+
+func TestConfig_redisStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		l2       string
+		want     string
+	}{
+		{name: "redis", strategy: "redis", want: "redis"},
+		{name: "redis-cluster", strategy: "redis-cluster", want: "redis-cluster"},
+		{name: "in-memory", strategy: "in-memory", want: ""},
+		{name: "tiered over redis", strategy: "tiered", l2: "redis", want: "redis"},
+		{name: "redis-sentinel", strategy: "redis-sentinel", want: "redis-sentinel"},
+		{name: "tiered over redis-cluster", strategy: "tiered", l2: "redis-cluster", want: "redis-cluster"},
+		{name: "tiered over redis-sentinel", strategy: "tiered", l2: "redis-sentinel", want: "redis-sentinel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{Strategy: tt.strategy, Tiered: TieredConfig{L2: tt.l2}}
+			assert.Equal(t, tt.want, conf.redisStrategy())
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Kong/go-pdk"
+	"github.com/eko/gocache/lib/v4/marshaler"
+	lib_store "github.com/eko/gocache/lib/v4/store"
+)
+
+// varyIndexPrefix 는 Vary 헤더 이름을 기록하는 스토어 키의 접두어다.
+const varyIndexPrefix = "sonic-boom:vary:"
+
+// varyIndex 는 업스트림이 응답한 Vary 헤더 이름이다.
+// Vary 를 반영하기 전의 캐시 키(URL, 라우트 등)마다 하나씩 기록한다.
+type varyIndex struct {
+	Headers []string
+}
+
+// parseVary 는 응답의 Vary 헤더 이름을 소문자로 정렬해 돌려준다. Vary: * 이면 wildcard 가 true 다.
+//
+// See https://www.rfc-editor.org/rfc/rfc9110#section-12.5.5
+func parseVary(headers map[string][]string) (names []string, wildcard bool) {
+	for k, values := range headers {
+		if !strings.EqualFold(k, "Vary") {
+			continue
+		}
+		for _, v := range values {
+			for _, name := range strings.Split(v, ",") {
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "" {
+					continue
+				}
+				if name == "*" {
+					return nil, true
+				}
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names), false
+}
+
+// responseVary 는 응답의 Vary 헤더 이름 가운데 vary_headers 설정에 없는 것만 돌려준다.
+// vary_headers 는 이미 캐시 키에 들어가 있다.
+func (conf *Config) responseVary(headers map[string][]string) (names []string, wildcard bool) {
+	names, wildcard = parseVary(headers)
+	return slices.DeleteFunc(names, func(name string) bool {
+		return slices.ContainsFunc(conf.VaryHeaders, func(h string) bool {
+			return strings.EqualFold(h, name)
+		})
+	}), wildcard
+}
+
+func (conf *Config) loadVaryIndex(marshal *marshaler.Marshaler, baseKeyID string) []string {
+	cached, err := marshal.Get(context.Background(), varyIndexPrefix+baseKeyID, new(varyIndex))
+	if cached == nil || err != nil {
+		return nil
+	}
+	return cached.(*varyIndex).Headers
+}
+
+// mayHaveVaryIndex 는 기록된 Vary 헤더 이름을 찾아봐야 하는지 알려준다.
+// configRuntime 이 있으면 이 프로세스가 이 설정값으로 기록한 적이 있을 때만 찾는다.
+// 다른 노드가 기록한 것은 처음 미스가 난 요청의 응답에서 다시 기록하며 알게 된다.
+func (conf *Config) mayHaveVaryIndex() bool {
+	return conf.rt == nil || conf.rt.varyIndexed.Load()
+}
+
+func (conf *Config) storeVaryIndex(marshal *marshaler.Marshaler, baseKeyID string, names []string, ttl int) error {
+	if len(names) == 0 {
+		return marshal.Delete(context.Background(), varyIndexPrefix+baseKeyID)
+	}
+	if conf.rt != nil {
+		conf.rt.varyIndexed.Store(true)
+	}
+	return marshal.Set(context.Background(), varyIndexPrefix+baseKeyID, &varyIndex{Headers: names}, lib_store.WithExpiration(time.Duration(ttl)*time.Second))
+}
+
+// varyCacheKey 는 기록된 Vary 헤더의 요청 값까지 반영해 캐시 키를 만든다.
+// baseKeyID 는 Vary 를 반영하기 전의 키이며, names 는 반영한 헤더 이름이다.
+// 기록된 Vary 헤더가 없으면 cacheKeyID 와 baseKeyID 는 같다.
+func (conf *Config) varyCacheKey(kong *pdk.PDK, marshal *marshaler.Marshaler, cacheKey *CacheKey) (cacheKeyID string, baseKeyID string, names []string, err error) {
+	logger := conf.logger
+
+	baseKeyID, err = generateCacheKeyID(logger, cacheKey)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if conf.mayHaveVaryIndex() {
+		names = conf.loadVaryIndex(marshal, baseKeyID)
+	}
+	if len(names) == 0 {
+		return baseKeyID, baseKeyID, nil, nil
+	}
+
+	if cacheKey.Headers == nil {
+		cacheKey.Headers = map[string]string{}
+	}
+	for _, name := range names {
+		v, err := kong.Request.GetHeader(name)
+		if err != nil {
+			logger.Debug().Err(err).Msgf("Failed to get request header `%s`", name)
+		}
+		// 헤더가 없는 요청도 따로 캐시해야 하므로 빈 값도 넣는다
+		cacheKey.Headers[name] = v
+	}
+
+	cacheKeyID, err = generateCacheKeyID(logger, cacheKey)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return cacheKeyID, baseKeyID, names, nil
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kong/go-pdk/bridge"
+	"github.com/Kong/go-pdk/bridge/bridgetest"
+	"github.com/Kong/go-pdk/server/kong_plugin_protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestParseVary(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      map[string][]string
+		wantNames    []string
+		wantWildcard bool
+	}{
+		{
+			name:      "no vary",
+			headers:   map[string][]string{"content-type": {"application/json"}},
+			wantNames: nil,
+		},
+		{
+			name:      "names are lowercased, sorted and deduplicated",
+			headers:   map[string][]string{"Vary": {"Accept-Language, accept-encoding", "Accept-Language"}},
+			wantNames: []string{"accept-encoding", "accept-language"},
+		},
+		{
+			name:         "wildcard",
+			headers:      map[string][]string{"vary": {"Accept, *"}},
+			wantWildcard: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, wildcard := parseVary(tt.headers)
+			assert.Equal(t, tt.wantNames, names)
+			assert.Equal(t, tt.wantWildcard, wildcard)
+		})
+	}
+}
+
+func TestConfig_responseVary(t *testing.T) {
+	cfg := configDefault()
+	cfg.VaryHeaders = []string{"Accept-Encoding"}
+
+	// vary_headers 로 이미 캐시 키에 들어간 헤더는 뺀다
+	names, wildcard := cfg.responseVary(map[string][]string{"vary": {"Accept-Encoding, Accept-Language"}})
+	assert.Equal(t, []string{"accept-language"}, names)
+	assert.False(t, wildcard)
+}
+
+func TestConfig_varyCacheKey(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	// Vary 인덱스는 캐시에 남으므로 다른 실행과 캐시를 나누지 않는다
+	isolateInMemoryForTest(t, cfg)
+	cfg.logger = NewLogger(&cfg.LogConf)
+	defer cfg.logger.Close()
+
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+
+	cacheKey := &CacheKey{Method: "GET", URL: "/vary"}
+	baseKeyID, err := generateCacheKeyID(cfg.logger, cacheKey)
+	require.NoError(t, err)
+
+	// 기록된 Vary 헤더가 없으면 기존 키를 그대로 쓴다
+	kong, recorder := mockPdkSteps(t, nil)
+	cacheKeyID, gotBase, names, err := cfg.varyCacheKey(kong, marshal, &CacheKey{Method: "GET", URL: "/vary"})
+	require.NoError(t, err)
+	recorder.assertConsumed()
+	assert.Equal(t, baseKeyID, cacheKeyID)
+	assert.Equal(t, baseKeyID, gotBase)
+	assert.Empty(t, names)
+
+	require.NoError(t, cfg.storeVaryIndex(marshal, baseKeyID, []string{"accept-language"}, 60))
+	require.Eventually(t, func() bool {
+		return len(cfg.loadVaryIndex(marshal, baseKeyID)) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	keyFor := func(language string) string {
+		kong, recorder := mockPdkSteps(t, []bridgetest.MockStep{
+			{Method: "kong.request.get_header", Args: bridge.WrapString("accept-language"), Ret: bridge.WrapString(language)},
+		})
+		cacheKeyID, gotBase, names, err := cfg.varyCacheKey(kong, marshal, &CacheKey{Method: "GET", URL: "/vary"})
+		require.NoError(t, err)
+		recorder.assertConsumed()
+		assert.Equal(t, baseKeyID, gotBase)
+		assert.Equal(t, []string{"accept-language"}, names)
+		return cacheKeyID
+	}
+
+	ko := keyFor("ko")
+	assert.NotEqual(t, baseKeyID, ko)
+	assert.NotEqual(t, ko, keyFor("en"))
+	assert.Equal(t, ko, keyFor("ko"))
+	assert.NotEqual(t, baseKeyID, keyFor(""))
+}
+
+func TestConfig_varyCacheKey_NotIndexed(t *testing.T) {
+	cfg := newInMemoryConfigForTest()
+	isolateInMemoryForTest(t, cfg)
+	conf := cfg.initialized()
+
+	_, marshal, err := conf.newCacheManager(60)
+	require.NoError(t, err)
+	baseKeyID, err := generateCacheKeyID(conf.logger, &CacheKey{Method: "GET", URL: "/vary-not-indexed"})
+	require.NoError(t, err)
+
+	// 이 설정값으로 기록한 적이 없으면 스토어에서 찾지 않는다
+	other := newInMemoryConfigForTest()
+	other.InMemory = cfg.InMemory
+	other.logger = conf.logger
+	require.NoError(t, other.storeVaryIndex(marshal, baseKeyID, []string{"accept-language"}, 60))
+	require.Eventually(t, func() bool {
+		return len(conf.loadVaryIndex(marshal, baseKeyID)) > 0
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, conf.mayHaveVaryIndex())
+
+	kong, recorder := mockPdkSteps(t, nil)
+	cacheKeyID, _, names, err := conf.varyCacheKey(kong, marshal, &CacheKey{Method: "GET", URL: "/vary-not-indexed"})
+	require.NoError(t, err)
+	recorder.assertConsumed()
+	assert.Equal(t, baseKeyID, cacheKeyID)
+	assert.Empty(t, names)
+
+	// 기록한 뒤로는 찾는다
+	require.NoError(t, conf.storeVaryIndex(marshal, baseKeyID, []string{"accept-language"}, 60))
+	assert.True(t, conf.mayHaveVaryIndex())
+	kong, recorder = mockPdkSteps(t, []bridgetest.MockStep{
+		{Method: "kong.request.get_header", Args: bridge.WrapString("accept-language"), Ret: bridge.WrapString("ko")},
+	})
+	cacheKeyID, _, names, err = conf.varyCacheKey(kong, marshal, &CacheKey{Method: "GET", URL: "/vary-not-indexed"})
+	require.NoError(t, err)
+	recorder.assertConsumed()
+	assert.NotEqual(t, baseKeyID, cacheKeyID)
+	assert.Equal(t, []string{"accept-language"}, names)
+}
+
+// responseStoreSteps 는 cache_control 모드가 아닐 때 Response 가 응답 본문을 읽기까지 호출하는 PDK 단계들이다.
+func responseStoreSteps(t *testing.T, signal CacheSignal, headers map[string][]string, body string) []bridgetest.MockStep {
+	wrapped, err := bridge.WrapHeaders(headers)
+	require.NoError(t, err)
+	reqBody, err := structpb.NewValue([]byte(nil))
+	require.NoError(t, err)
+
+	return append(responseSignalSteps(t, http.StatusOK, signal),
+		bridgetest.MockStep{Method: "kong.response.get_status", Ret: &kong_plugin_protocol.Int{V: http.StatusOK}},
+		bridgetest.MockStep{Method: "kong.nginx.get_var", Args: bridge.WrapString("sent_http_content_type"), Ret: bridge.WrapString("application/json")},
+		bridgetest.MockStep{Method: "kong.response.get_headers", Ret: wrapped},
+		bridgetest.MockStep{Method: "kong.service.response.get_raw_body", Ret: &kong_plugin_protocol.RawBodyResult{Kind: &kong_plugin_protocol.RawBodyResult_Content{Content: []byte(body)}}},
+		bridgetest.MockStep{Method: "kong.ctx.shared.get", Args: bridge.WrapString("reqBody"), Ret: reqBody},
+	)
+}
+
+func Test_Response_InMemory_Vary(t *testing.T) {
+	disableOtelForTest(t)
+
+	cfg := newInMemoryConfigForTest()
+	cfg.CacheTTL = 60
+	cfg.CacheVersion = Version
+
+	_, marshal, err := cfg.newCacheManager(60)
+	require.NoError(t, err)
+
+	t.Run("wildcard is not cached", func(t *testing.T) {
+		signal := CacheSignal{CacheKeyID: "vary-wildcard", CacheTTL: 60, BaseKeyID: "vary-wildcard"}
+		wrapped, err := bridge.WrapHeaders(map[string][]string{"vary": {"*"}})
+		require.NoError(t, err)
+
+		steps := append(responseSignalSteps(t, http.StatusOK, signal),
+			bridgetest.MockStep{Method: "kong.response.get_status", Ret: &kong_plugin_protocol.Int{V: http.StatusOK}},
+			bridgetest.MockStep{Method: "kong.nginx.get_var", Args: bridge.WrapString("sent_http_content_type"), Ret: bridge.WrapString("application/json")},
+			bridgetest.MockStep{Method: "kong.response.get_headers", Ret: wrapped},
+			bridgetest.MockStep{Method: "kong.response.set_header", Args: &kong_plugin_protocol.KV{K: "X-Cache-Status", V: structpb.NewStringValue("Bypass")}},
+		)
+		kong, recorder := mockPdkSteps(t, steps)
+		cfg.Response(kong)
+		recorder.assertConsumed()
+	})
+
+	t.Run("changed vary is recorded instead of stored", func(t *testing.T) {
+		signal := CacheSignal{CacheKeyID: "vary-changed", CacheTTL: 60, BaseKeyID: "vary-changed"}
+		kong, recorder := mockPdkSteps(t, responseStoreSteps(t, signal, map[string][]string{"vary": {"Accept-Language"}}, "{}"))
+		cfg.Response(kong)
+		recorder.assertConsumed()
+
+		require.Eventually(t, func() bool {
+			return len(cfg.loadVaryIndex(marshal, "vary-changed")) > 0
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"accept-language"}, cfg.loadVaryIndex(marshal, "vary-changed"))
+		assert.Nil(t, getCacheValue(context.Background(), marshal, "vary-changed"))
+	})
+
+	t.Run("same vary is stored", func(t *testing.T) {
+		signal := CacheSignal{CacheKeyID: "vary-same-ko", CacheTTL: 60, BaseKeyID: "vary-same", VaryHeaders: []string{"accept-language"}}
+		kong, recorder := mockPdkSteps(t, responseStoreSteps(t, signal, map[string][]string{"vary": {"Accept-Language"}}, "{}"))
+		cfg.Response(kong)
+		recorder.assertConsumed()
+
+		require.Eventually(t, func() bool {
+			return getCacheValue(context.Background(), marshal, "vary-same-ko") != nil
+		}, time.Second, 10*time.Millisecond)
+	})
+}
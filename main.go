@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"time"
 
@@ -55,6 +56,14 @@ func main() {
 		}
 	}()
 
+	// -dump 는 플러그인 정보만 출력하고 끝나므로 purge API 를 열지 않는다
+	flag.Parse()
+	if dump := flag.Lookup("dump"); dump == nil || dump.Value.String() != "true" {
+		if err := internal.StartPurgeServer(); err != nil {
+			log.Fatalf("Failed to start purge API: %v", err)
+		}
+	}
+
 	internal.New()
 	err = server.StartServer(internal.New, internal.Version, internal.Priority)
 	if err != nil {